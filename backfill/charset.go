@@ -0,0 +1,72 @@
+package backfill
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// CharsetSniffLen is how much of a document is searched for a <meta> charset
+// declaration, the same as browsers.
+const CharsetSniffLen = 1024
+
+// DecodeCharset converts a document to UTF-8 for the tokenizer, so links with
+// accented characters survive on ISO-8859-1, Shift-JIS and other legacy
+// pages. The charset comes from contentType, the response's Content-Type
+// header, or failing that from a <meta> tag near the start of the document.
+// Documents that don't declare one, or declare one that isn't known, are read
+// as UTF-8.
+func DecodeCharset(r io.Reader, contentType string) io.Reader {
+	buffered := bufio.NewReaderSize(r, CharsetSniffLen)
+	label := ""
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		label = params["charset"]
+	}
+	if label == "" {
+		// A short document gives an error along with all of itself.
+		head, _ := buffered.Peek(CharsetSniffLen)
+		label = MetaCharset(head)
+	}
+	if label == "" || strings.EqualFold(label, "utf-8") {
+		return buffered
+	}
+	decoded, err := charset.NewReaderLabel(label, buffered)
+	if err != nil {
+		return buffered
+	}
+	return decoded
+}
+
+// MetaCharset gets the charset declared by a <meta charset> or <meta
+// http-equiv="Content-Type"> tag in the start of a document, or "" if there is
+// none.
+func MetaCharset(head []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(head))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			if t.DataAtom != atom.Meta {
+				continue
+			}
+			if label, err := GetAttr(t, "charset"); err == nil && strings.TrimSpace(label) != "" {
+				return strings.TrimSpace(label)
+			}
+			if equiv, _ := GetAttr(t, "http-equiv"); !strings.EqualFold(strings.TrimSpace(equiv), "content-type") {
+				continue
+			}
+			content, _ := GetAttr(t, "content")
+			if _, params, err := mime.ParseMediaType(content); err == nil && params["charset"] != "" {
+				return params["charset"]
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package backfill
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	cssImport = regexp.MustCompile(`@import\s+(?:url\(\s*)?['"]?([^'")\s;]+)`)
+	cssURL    = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+)
+
+// ParseCSS finds the URLs a stylesheet references, resolved against base: the
+// stylesheets it pulls in with @import, and everything else (fonts, images)
+// it loads with url(). Inline data: URLs are skipped.
+func ParseCSS(base *url.URL, r io.Reader) (imports []url.URL, refs []url.URL, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	css := string(data)
+
+	seen := make(map[string]bool)
+	resolve := func(ref string) (url.URL, bool) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || strings.HasPrefix(strings.ToLower(ref), "data:") || seen[ref] {
+			return url.URL{}, false
+		}
+		seen[ref] = true
+		link, err := base.Parse(ref)
+		if err != nil {
+			return url.URL{}, false
+		}
+		return *link, true
+	}
+	for _, match := range cssImport.FindAllStringSubmatch(css, -1) {
+		if link, ok := resolve(match[1]); ok {
+			imports = append(imports, link)
+		}
+	}
+	for _, match := range cssURL.FindAllStringSubmatch(css, -1) {
+		if link, ok := resolve(match[1]); ok {
+			refs = append(refs, link)
+		}
+	}
+	return imports, refs, nil
+}
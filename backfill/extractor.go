@@ -0,0 +1,90 @@
+package backfill
+
+import (
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// ExtractKind is what an Extractor adds the URLs it finds as.
+type ExtractKind int
+
+const (
+	// ExtractLink adds a link, followed if it's on the same host.
+	ExtractLink ExtractKind = iota + 1
+	// ExtractAsset adds a static asset.
+	ExtractAsset
+	// ExtractEmbedded adds one of the document's EmbeddedLinks.
+	ExtractEmbedded
+)
+
+// Extractor is a rule for VisitExtractors: the URL in attribute Attr of every
+// Tag element is added as Kind. Tag is the element's lower-case name, so custom
+// elements like "product-card" work too.
+type Extractor struct {
+	Tag  string
+	Attr string
+	Kind ExtractKind
+}
+
+// DefaultExtractors are the built-in rules, for the elements simple enough to
+// be data. <a> and <link> aren't among them: they depend on rel and type
+// attributes, so VisitLinks, VisitAssets, VisitPagination and VisitFeeds handle
+// them, and ParseOptions.Extractors can't change them.
+var DefaultExtractors = []Extractor{
+	{"img", "src", ExtractAsset},
+	{"script", "src", ExtractAsset}}
+
+var (
+	extractorsMu sync.RWMutex
+	// extractors holds DefaultExtractors and then the registered rules. It's
+	// only ever appended to, so a slice of it read under the lock stays valid.
+	extractors = append([]Extractor(nil), DefaultExtractors...)
+)
+
+// RegisterExtractor adds a rule for VisitExtractors, e.g.
+// RegisterExtractor("product-card", "data-href", ExtractLink), for sites with
+// custom elements that carry URLs in attributes the parser doesn't know about.
+// Rules apply to every crawl in the process that doesn't set its own
+// ParseOptions.Extractors, so register them before crawling, e.g. in an init
+// function.
+func RegisterExtractor(tag, attr string, kind ExtractKind) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors = append(extractors, Extractor{tag, attr, kind})
+}
+
+// Extractors lists the registered rules, built-in ones first.
+func Extractors() []Extractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	return append([]Extractor(nil), extractors...)
+}
+
+// VisitExtractors adds the URLs that the options' Extractors, or else the
+// registered ones, pick out of an element.
+func VisitExtractors(x *Extraction, n *html.Node, t html.Token) {
+	rules := x.Options.Extractors
+	if rules == nil {
+		extractorsMu.RLock()
+		rules = extractors
+		extractorsMu.RUnlock()
+	}
+	for _, rule := range rules {
+		if rule.Tag != t.Data {
+			continue
+		}
+		link, err := GetAttrURL(x.Host, t, rule.Attr)
+		if err != nil || link.String() == "" {
+			continue
+		}
+		switch rule.Kind {
+		case ExtractLink:
+			x.SortLink(*link)
+		case ExtractAsset:
+			x.AddAsset(link)
+		case ExtractEmbedded:
+			x.AddEmbeddedLink(link.String())
+		}
+	}
+}
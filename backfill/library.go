@@ -2,9 +2,16 @@ package backfill
 
 import (
 	"errors"
+	"mime"
+	"net"
+	"net/http"
 	"net/url"
+	"path"
+	"slices"
+	"strings"
 
 	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
 )
 
 // GetAttr gets the value for an attribute key in an HTML open tag.
@@ -19,28 +26,117 @@ func GetAttr(t html.Token, key string) (string, error) {
 	return "", err // attr not found
 }
 
-// GetAttrURL get an absolute URL from a specific attribute key.
+// GetAttrURL get an absolute URL from a specific attribute key, resolved with
+// ResolveURL. An empty value gives an empty URL, and values that don't parse as
+// URLs are an error.
 func GetAttrURL(host *url.URL, t html.Token, key string) (link *url.URL, err error) {
 	val, err := GetAttr(t, key)
 	if err != nil {
 		return link, err
 	}
+	if strings.TrimSpace(val) == "" {
+		return &url.URL{}, nil
+	}
+	return ResolveURL(host, val)
+}
 
-	link, err = url.Parse(val)
+// ResolveURL parses a URL found in a document and resolves it against the
+// document's URL like a browser would, so relative paths, dot segments,
+// query-only and protocol-relative ("//cdn.example.com/x") references all come
+// out absolute. Surrounding whitespace is ignored.
+func ResolveURL(host *url.URL, raw string) (*url.URL, error) {
+	ref, err := url.Parse(strings.TrimSpace(raw))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-
-	RelToAbsURL(host, link)
+	link := host.ResolveReference(ref)
 	FixScheme(link)
-	return link, err
+	return link, nil
 }
 
-// RelToAbsURL gets an absolute URL from a relative one.
-func RelToAbsURL(host *url.URL, link *url.URL) {
-	if !link.IsAbs() {
-		link.Host = host.Host
+// HasRel determines if an element's space-separated rel attribute includes a
+// value, ignoring case.
+func HasRel(t html.Token, value string) bool {
+	rel, err := GetAttr(t, "rel")
+	if err != nil {
+		return false
+	}
+	for _, field := range strings.Fields(rel) {
+		if strings.EqualFold(field, value) {
+			return true
+		}
 	}
+	return false
+}
+
+// ParseRefresh gets the target URL out of a <meta http-equiv="refresh">
+// content attribute like "0;url=/new". It accepts what browsers do: a comma
+// instead of the semicolon, spaces around the separators, any case of "url",
+// a missing "url=", and a quoted URL. A plain delay like "30" reloads the page
+// itself, so it has no target.
+func ParseRefresh(content string) (string, bool) {
+	rest := strings.TrimLeft(content, " \t\n\r\f")
+	// The delay is digits, maybe with a fraction that's ignored.
+	delay := strings.IndexFunc(rest, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if delay <= 0 {
+		return "", false
+	}
+	rest = strings.TrimLeft(rest[delay:], " \t\n\r\f")
+	if rest == "" || (rest[0] != ';' && rest[0] != ',') {
+		return "", false
+	}
+	rest = strings.TrimLeft(rest[1:], " \t\n\r\f")
+	if len(rest) >= 3 && strings.EqualFold(rest[:3], "url") {
+		after := strings.TrimLeft(rest[3:], " \t\n\r\f")
+		if strings.HasPrefix(after, "=") {
+			rest = strings.TrimLeft(after[1:], " \t\n\r\f")
+		}
+	}
+	if rest != "" && (rest[0] == '\'' || rest[0] == '"') {
+		quote := rest[0]
+		rest = rest[1:]
+		if end := strings.IndexByte(rest, quote); end >= 0 {
+			rest = rest[:end]
+		}
+	}
+	rest = strings.TrimSpace(rest)
+	return rest, rest != ""
+}
+
+// FeedTypes maps the media types of feeds to their Feed kind.
+var FeedTypes = map[string]string{
+	"application/rss+xml":   FeedRSS,
+	"application/atom+xml":  FeedAtom,
+	"application/feed+json": FeedJSON,
+}
+
+// LinkFeed gets the Feed kind of a <link> element, or "" if it isn't a feed:
+// rel="alternate" with one of the FeedTypes, or rel="search" with the
+// OpenSearch description type. Plain application/json alternates are usually
+// APIs, not feeds, so they don't count.
+func LinkFeed(t html.Token) string {
+	kind, err := GetAttr(t, "type")
+	if err != nil {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(kind)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case HasRel(t, "alternate"):
+		return FeedTypes[mediaType]
+	case HasRel(t, "search") && mediaType == "application/opensearchdescription+xml":
+		return FeedOpenSearch
+	}
+	return ""
+}
+
+// RelToAbsURL gets an absolute URL from a relative one, resolved against host.
+func RelToAbsURL(host *url.URL, link *url.URL) {
+	*link = *host.ResolveReference(link)
 }
 
 // FixScheme adds default HTTP scheme to URLs without it.
@@ -50,7 +146,284 @@ func FixScheme(link *url.URL) {
 	}
 }
 
-// SameHost determines if two URLs share the same host.
+// HasExtension determines if a URL's path ends in one of the file extensions,
+// ignoring case. Extensions may be given with or without the leading dot.
+func HasExtension(link *url.URL, extensions []string) bool {
+	ext := strings.TrimPrefix(path.Ext(link.Path), ".")
+	if ext == "" {
+		return false
+	}
+	for _, skip := range extensions {
+		if strings.EqualFold(ext, strings.TrimPrefix(skip, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPathPrefix determines if a URL's path starts with one of the prefixes. A
+// prefix without a leading slash gets one, so "docs/" matches /docs/intro. No
+// prefixes at all matches every path.
+func HasPathPrefix(link *url.URL, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			prefix = "/" + prefix
+		}
+		if strings.HasPrefix(link.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultSchemes are the URL schemes crawled when none are configured.
+var DefaultSchemes = []string{"http", "https"}
+
+// SchemeAllowed determines if a URL's scheme is one of schemes, or one of the
+// DefaultSchemes if schemes is nil.
+func SchemeAllowed(link *url.URL, schemes []string) bool {
+	if schemes == nil {
+		schemes = DefaultSchemes
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(link.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// RewriteHost gets a copy of a URL with its host swapped according to rewrites,
+// e.g. {"www.prod.com": "staging.prod.com"}.
+func RewriteHost(link *url.URL, rewrites map[string]string) url.URL {
+	rewritten := *link
+	if host, ok := rewrites[link.Host]; ok {
+		rewritten.Host = host
+	}
+	return rewritten
+}
+
+// SameHost determines if two URLs share the same host. Hosts are compared like
+// browsers compare origins, except for the scheme: case doesn't matter and an
+// explicit default port (:80 for http, :443 for https) is the same as none, but
+// any other port makes a different host, since it's usually a different server.
+// Userinfo is never part of the host, so credentials don't matter either.
 func SameHost(u *url.URL, v *url.URL) bool {
-	return u.Host == v.Host
+	return CanonicalHost(u) == CanonicalHost(v)
+}
+
+// CanonicalHost gets a URL's host in lower case, without the port if it is the
+// scheme's default.
+func CanonicalHost(link *url.URL) string {
+	host := strings.ToLower(link.Host)
+	if port := link.Port(); port != "" && port == DefaultPort(link.Scheme) {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+	return host
+}
+
+// DefaultPort gets the port a scheme uses when a URL doesn't give one, or "" if
+// it isn't a scheme the crawler knows.
+func DefaultPort(scheme string) string {
+	switch strings.ToLower(scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
+
+// SameRegisteredDomain determines if two URLs are on the same registered domain,
+// e.g. www.example.co.uk and blog.example.co.uk. Ports are ignored. Hosts with no
+// registered domain, like IP addresses and localhost, only match themselves.
+func SameRegisteredDomain(u *url.URL, v *url.URL) bool {
+	return RegisteredDomain(u.Hostname()) == RegisteredDomain(v.Hostname())
+}
+
+// RegisteredDomain gets the part of a hostname bought from a registrar, one label
+// more than its public suffix, or the hostname itself if it doesn't have one.
+func RegisteredDomain(host string) string {
+	host = strings.ToLower(host)
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// NormalizeOptions controls the optional parts of NormalizeURL.
+type NormalizeOptions struct {
+	// HashRouting keeps fragments that look like client-side routes.
+	HashRouting bool
+
+	// CaseInsensitivePaths lowercases the path, for servers where /About and
+	// /about are the same page.
+	CaseInsensitivePaths bool
+}
+
+// NormalizeURL cleans up a URL so equivalent links map to the same sitemap key.
+// Percent-encoding in the path and query is normalized as RFC 3986 describes,
+// so /%7euser?q=a%2fb and /~user?q=a%2Fb are the same page. Fragments never
+// reach the server, so they are stripped unless HashRouting is set and the
+// fragment looks like a client-side route (#/path or #!/path). The host is
+// lower cased and an explicit default port dropped, see CanonicalHost. Userinfo
+// is dropped, so user@host/x and host/x are the same page and credentials never
+// end up in the sitemap; send them with CrawlerConfig.Headers instead.
+func NormalizeURL(link *url.URL, options NormalizeOptions) {
+	link.User = nil
+	link.Host = CanonicalHost(link)
+	if options.CaseInsensitivePaths {
+		link.Path = strings.ToLower(link.Path)
+		link.RawPath = strings.ToLower(link.RawPath)
+	}
+	link.RawPath = NormalizeEscapes(link.RawPath)
+	link.RawQuery = NormalizeEscapes(link.RawQuery)
+	if options.HashRouting && IsHashRoute(link.Fragment) {
+		return
+	}
+	link.Fragment = ""
+	link.RawFragment = ""
+}
+
+// NormalizeEscapes normalizes the percent-encoding in part of a URL: escaped
+// unreserved characters (letters, digits, "-", ".", "_" and "~") are decoded and
+// every other escape gets upper case hex digits. Malformed escapes are kept.
+func NormalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); i += 1 {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			out.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			out.WriteByte(c)
+		} else {
+			out.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+	return out.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// IsHashRoute reports whether a fragment is a client-side route rather than an
+// in-page anchor.
+func IsHashRoute(fragment string) bool {
+	return strings.HasPrefix(fragment, "/") || strings.HasPrefix(fragment, "!/")
+}
+
+// RelativeURL formats a link relative to base's host, as its path plus any
+// query string and fragment, e.g. "/docs/x?page=2". The root is "/". Links on
+// other hosts, going by SameHost, stay absolute.
+func RelativeURL(base, link *url.URL) string {
+	if !SameHost(link, base) {
+		return link.String()
+	}
+	relative := url.URL{
+		Path:        link.Path,
+		RawPath:     link.RawPath,
+		RawQuery:    link.RawQuery,
+		Fragment:    link.Fragment,
+		RawFragment: link.RawFragment}
+	if relative.Path == "" {
+		relative.Path = "/"
+		relative.RawPath = ""
+	}
+	return relative.String()
+}
+
+// URLKey gets the key a normalized URL is stored under in the sitemap. Pages
+// that differ only by query string are different pages.
+func URLKey(link *url.URL) string {
+	key := link.Path
+	if link.RawQuery != "" {
+		key += "?" + link.RawQuery
+	}
+	if link.Fragment != "" {
+		key += "#" + link.Fragment
+	}
+	return key
+}
+
+// PaginationGroup gets the key a paginated URL is grouped under: its URLKey with
+// the given pagination parameters removed from the query string. It returns ""
+// if the URL has none of them, so unpaginated pages don't form groups.
+func PaginationGroup(link *url.URL, params []string) string {
+	if link.RawQuery == "" || len(params) == 0 {
+		return ""
+	}
+	var kept []string
+	paginated := false
+	for _, pair := range strings.Split(link.RawQuery, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(name); err == nil && slices.Contains(params, name) {
+			paginated = true
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	if !paginated {
+		return ""
+	}
+	group := *link
+	group.RawQuery = strings.Join(kept, "&")
+	return URLKey(&group)
+}
+
+// HTMLContentTypes are the media types treated as HTML documents.
+var HTMLContentTypes = []string{"text/html", "application/xhtml+xml"}
+
+// IsHTML determines if a response holds an HTML document. Responses without a
+// Content-Type get the benefit of the doubt.
+func IsHTML(response *http.Response) bool {
+	return HasContentType(response, HTMLContentTypes)
+}
+
+// HasContentType determines if a response's media type is one of types.
+// Parameters such as charset are ignored, and responses without a
+// Content-Type get the benefit of the doubt.
+func HasContentType(response *http.Response, types []string) bool {
+	contentType := response.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, kind := range types {
+		if strings.EqualFold(mediaType, kind) {
+			return true
+		}
+	}
+	return false
 }
@@ -1,51 +1,288 @@
 package backfill
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 )
 
-// ParseAssets parses links and static assets out of an HTML document.
-func ParseAssets(response *http.Response) (links []url.URL, assets []string) {
-	host := response.Request.URL
+// Asset is a static asset (image, script, stylesheet) referenced by a page.
+// External assets are served from a different host than the page.
+type Asset struct {
+	URL      string `json:"url"`
+	External bool   `json:"external"`
+}
+
+// FormInfo is a <form> found on a page. Method is upper case and defaults to GET.
+type FormInfo struct {
+	Action url.URL
+	Method string
+}
+
+// Feed is a feed or OpenSearch description a page advertises with a <link>.
+// Kind is one of the Feed kinds, from the link's type attribute.
+type Feed struct {
+	URL  string `json:"url"`
+	Kind string `json:"kind"`
+}
+
+// Feed kinds.
+const (
+	FeedRSS        = "rss"
+	FeedAtom       = "atom"
+	FeedJSON       = "json"
+	FeedOpenSearch = "opensearch"
+)
+
+// AssetExtensionFilter picks the assets that are recorded by file extension,
+// e.g. Exclude: {"woff2", "mp4", "pdf"}. Matching ignores case and any query
+// string. With Include set only assets with one of its extensions are kept,
+// so assets without an extension are dropped. Exclude is applied after it.
+// The zero filter keeps every asset.
+type AssetExtensionFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Allows determines if an asset passes the filter.
+func (f AssetExtensionFilter) Allows(link *url.URL) bool {
+	if len(f.Include) > 0 && !HasExtension(link, f.Include) {
+		return false
+	}
+	return !HasExtension(link, f.Exclude)
+}
+
+// ParseOptions controls what ParseAssets extracts from a document.
+type ParseOptions struct {
+	// ExternalAssets keeps assets from other hosts instead of dropping them.
+	ExternalAssets bool
+
+	// ExternalLinks keeps links to other hosts in Document.ExternalLinks
+	// instead of dropping them.
+	ExternalLinks bool
+
+	// AssetExtensions drops assets by file extension before they're added.
+	AssetExtensions AssetExtensionFilter
+
+	// HostRewrite maps hosts that should count as the page's own host, for
+	// crawling a staging copy of a site whose links point at production.
+	HostRewrite map[string]string
+
+	// Logf receives warnings about malformed markup. Nil uses log.Printf.
+	Logf func(format string, v ...any)
+
+	// OnLink and OnAsset, if set, receive each link or asset as soon as it is
+	// found instead of having it collected on the Document. Setting either
+	// also has ParseDocument tokenize the document as it's read rather than
+	// build a tree of it, see StreamDocument, so memory doesn't grow with the
+	// size of the page.
+	OnLink  func(link url.URL, kind LinkKind)
+	OnAsset func(Asset)
+
+	// AllowedSchemes are the URL schemes links and assets may have. Anything
+	// else is dropped, or kept in OtherLinks if OtherSchemes is set. Nil uses
+	// DefaultSchemes.
+	AllowedSchemes []string
+	OtherSchemes   bool
+
+	// Noscript extracts links and assets from <noscript> fallback content too.
+	// By default it is skipped, since it only shows without JavaScript and
+	// mostly repeats what the scripted page loads.
+	Noscript bool
+
+	// Visitors pull links, assets and anything else out of the document's
+	// elements. Nil uses DefaultVisitors.
+	Visitors []Visitor
+
+	// Extractors are the rules VisitExtractors applies. Nil uses the ones
+	// registered with RegisterExtractor, which start with DefaultExtractors.
+	Extractors []Extractor
+}
+
+// LinkKind is how a link passed to OnLink was found.
+type LinkKind int
+
+const (
+	// LinkPlain is an <a href>, a meta refresh or an Extractor's link.
+	LinkPlain LinkKind = iota
+	// LinkForm is the action of a GET form.
+	LinkForm
+	// LinkFrame is the src of an <iframe> or <frame>.
+	LinkFrame
+	// LinkPagination is a <link rel="next"> or <link rel="prev">.
+	LinkPagination
+)
+
+// Document holds everything ParseAssets extracts from a page.
+type Document struct {
+	Links  []url.URL
+	Assets []Asset
+
+	// Title is the text of the <title>, with whitespace collapsed.
+	Title string
+
+	// Lang is the <html lang> attribute, or "" if the document doesn't
+	// declare its language.
+	Lang string
+
+	// NextPage and PrevPage are the same-host <link rel="next"> and
+	// <link rel="prev"> targets, if the document is part of a paginated
+	// series. They're in Links too.
+	NextPage url.URL
+	PrevPage url.URL
+
+	// Frames holds the same-host <iframe> and <frame> sources. They're in
+	// Links too.
+	Frames []url.URL
 
-	z := html.NewTokenizer(response.Body)
+	// RefreshURL is where the document's <meta http-equiv="refresh"> sends
+	// the browser, on any host. It's in Links too if it's on the same host.
+	RefreshURL url.URL
+
+	// Feeds holds the RSS, Atom and JSON feeds from <link rel="alternate">
+	// and OpenSearch descriptions from <link rel="search">, on any host.
+	// They aren't crawled.
+	Feeds []Feed
+
+	// StructuredData holds the JSON-LD blocks (<script type="application/ld+json">).
+	StructuredData []json.RawMessage
+
+	// Forms holds every form on the page. Same-host GET forms are also in Links.
+	Forms []FormInfo
+
+	// EmbeddedLinks holds same-host URLs found outside of <a> tags by the
+	// opt-in VisitJSONLDLinks and DataAttributeLinks visitors. They're kept
+	// apart from Links since they're more likely not to be pages.
+	EmbeddedLinks []url.URL
+
+	// ExternalLinks holds links to other hosts, if the ExternalLinks option is
+	// set. They are never crawled.
+	ExternalLinks []url.URL
+
+	// OtherLinks holds links with schemes that aren't crawled, like mailto: and
+	// tel:, if the OtherSchemes option is set.
+	OtherLinks []url.URL
+}
+
+// ParseAssets parses links and static assets out of an HTML document.
+// The document is parsed into a tree the same way a browser would, so unclosed
+// tags and stray markup don't hide the links that come after them. Documents
+// in other charsets are decoded to UTF-8 first, see DecodeCharset.
+func ParseAssets(response *http.Response, options ParseOptions) Document {
 	defer response.Body.Close()
+	body := DecodeCharset(response.Body, response.Header.Get("Content-Type"))
+	return ParseDocument(response.Request.URL, body, options)
+}
+
+// ParseDocument is ParseAssets for a document that has already been read from
+// its response. Relative URLs are resolved against host. Every element is
+// handed to each of the options' Visitors in turn, or DefaultVisitors if none
+// are set. With OnLink or OnAsset set it hands over to StreamDocument.
+func ParseDocument(host *url.URL, r io.Reader, options ParseOptions) (doc Document) {
+	if options.OnLink != nil || options.OnAsset != nil {
+		return StreamDocument(host, r, options)
+	}
+	// Like a browser with scripting on, the parser normally keeps <noscript>
+	// content as plain text; turning scripting off parses it as markup.
+	root, err := html.ParseWithOptions(r, html.ParseOptionEnableScripting(!options.Noscript))
+	if err != nil {
+		return doc
+	}
+	visitors := options.Visitors
+	if visitors == nil {
+		visitors = DefaultVisitors
+	}
+	x := &Extraction{Host: host, Options: options, Doc: &doc}
 
-Loop:
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.DataAtom == atom.Noscript && !options.Noscript {
+				return
+			}
+			t := html.Token{
+				Type:     html.StartTagToken,
+				DataAtom: n.DataAtom,
+				Data:     n.Data,
+				Attr:     n.Attr}
+			for _, visitor := range visitors {
+				visitor(x, n, t)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(root)
+	return doc
+}
+
+// StreamDocument is ParseDocument without the tree: the document is tokenized
+// as it's read and each start tag handed to the visitors on its own, so only
+// the current token is held in memory. Visitors get an element without its
+// children, except that <title> and <script> get their text, and can't rely
+// on the parser's fixes for broken markup. <noscript> content is tokenized
+// too if Noscript is set.
+func StreamDocument(host *url.URL, r io.Reader, options ParseOptions) (doc Document) {
+	visitors := options.Visitors
+	if visitors == nil {
+		visitors = DefaultVisitors
+	}
+	x := &Extraction{Host: host, Options: options, Doc: &doc}
+	streamTokens(x, visitors, html.NewTokenizer(r))
+	return doc
+}
+
+// streamTokens runs the visitors over every start tag z reads. svg counts the
+// <svg> elements the tokenizer is inside, for VisitTitle.
+func streamTokens(x *Extraction, visitors []Visitor, z *html.Tokenizer) {
+	svg := 0
 	for {
-		tt := z.Next()
-		switch {
-		case tt == html.ErrorToken:
-			// Done parsing the document
-			break Loop
-		case tt == html.StartTagToken:
+		switch z.Next() {
+		case html.ErrorToken:
+			return
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "svg" && svg > 0 {
+				svg -= 1
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
 			t := z.Token()
-			switch t.DataAtom {
-			// Links: <a>
-			case atom.A:
-				href, err := GetAttrURL(host, t, "href")
-				if err == nil && SameHost(host, href) && len(href.String()) > 0 {
-					FixScheme(href)
-					links = append(links, *href)
+			n := &html.Node{Type: html.ElementNode, DataAtom: t.DataAtom, Data: t.Data, Attr: t.Attr}
+			if t.DataAtom == atom.Svg && t.Type == html.StartTagToken {
+				svg += 1
+			}
+			if svg > 0 {
+				n.Namespace = "svg"
+			}
+			// The tokenizer reads the content of these as one text token
+			// instead of as markup.
+			next := html.StartTagToken
+			if t.DataAtom == atom.Title || t.DataAtom == atom.Script || t.DataAtom == atom.Noscript {
+				if next = z.Next(); next == html.TextToken {
+					n.AppendChild(&html.Node{Type: html.TextNode, Data: string(z.Text())})
 				}
-			// Images: <img>, Javascript: <script>
-			case atom.Img, atom.Script:
-				src, err := GetAttrURL(host, t, "src")
-				if err == nil && SameHost(host, src) {
-					assets = append(assets, src.String())
+			}
+			if t.DataAtom != atom.Noscript || x.Options.Noscript {
+				for _, visitor := range visitors {
+					visitor(x, n, t)
 				}
-			// CSS: <link>
-			case atom.Link:
-				href, err := GetAttrURL(host, t, "href")
-				if err == nil && SameHost(host, href) {
-					assets = append(assets, href.String())
+				if t.DataAtom == atom.Noscript && n.FirstChild != nil {
+					streamTokens(x, visitors, html.NewTokenizer(strings.NewReader(n.FirstChild.Data)))
 				}
 			}
+			if next == html.ErrorToken {
+				return
+			}
 		}
 	}
-	return links, assets
+}
+
+// IsJSONLD determines if a <script> type attribute marks a JSON-LD block.
+func IsJSONLD(kind string) bool {
+	return strings.EqualFold(strings.TrimSpace(kind), "application/ld+json")
 }
@@ -0,0 +1,220 @@
+package backfill
+
+import (
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// linkPaths gets the paths of a document's links, in order.
+func linkPaths(doc Document) []string {
+	paths := make([]string, len(doc.Links))
+	for i, link := range doc.Links {
+		paths[i] = link.Path
+	}
+	return paths
+}
+
+func TestParseDocumentNoscript(t *testing.T) {
+	host, _ := url.Parse("http://example.com/")
+	page := `<html><body>
+<a href="/live">Live</a>
+<noscript><a href="/fallback">Fallback</a><img src="/pixel.gif"></noscript>
+</body></html>`
+	tests := []struct {
+		noscript bool
+		links    string
+		assets   int
+	}{
+		// <noscript> content is skipped unless asked for.
+		{false, "/live", 0},
+		{true, "/live /fallback", 1},
+	}
+	for _, test := range tests {
+		doc := ParseDocument(host, strings.NewReader(page), ParseOptions{Noscript: test.noscript})
+		if got := strings.Join(linkPaths(doc), " "); got != test.links {
+			t.Errorf("Noscript %v: links = %q, want %q", test.noscript, got, test.links)
+		}
+		if len(doc.Assets) != test.assets {
+			t.Errorf("Noscript %v: assets = %v, want %d", test.noscript, doc.Assets, test.assets)
+		}
+	}
+}
+
+func TestParseDocumentExtractors(t *testing.T) {
+	host, _ := url.Parse("http://example.com/")
+	page := `<html><body>
+<a href="/live">Live</a>
+<img src="/logo.png">
+<product-card data-href="/product"></product-card>
+</body></html>`
+	tests := []struct {
+		extractors []Extractor
+		links      string
+		assets     int
+	}{
+		// Nil uses the registered rules, which don't know about product-card.
+		{nil, "/live", 1},
+		// Setting them replaces the registered ones, images included.
+		{[]Extractor{{"product-card", "data-href", ExtractLink}}, "/live /product", 0},
+	}
+	for _, test := range tests {
+		doc := ParseDocument(host, strings.NewReader(page), ParseOptions{Extractors: test.extractors})
+		if got := strings.Join(linkPaths(doc), " "); got != test.links {
+			t.Errorf("Extractors %v: links = %q, want %q", test.extractors, got, test.links)
+		}
+		if len(doc.Assets) != test.assets {
+			t.Errorf("Extractors %v: assets = %v, want %d", test.extractors, doc.Assets, test.assets)
+		}
+	}
+}
+
+func TestParseDocumentLang(t *testing.T) {
+	host, _ := url.Parse("http://example.com/")
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<html lang="en-US"><body></body></html>`, "en-US"},
+		{`<html lang=" fr "><body></body></html>`, "fr"},
+		{`<html lang=""><body></body></html>`, ""},
+		{`<html><body></body></html>`, ""},
+		{`<p>No html tag at all</p>`, ""},
+	}
+	for _, test := range tests {
+		doc := ParseDocument(host, strings.NewReader(test.html), ParseOptions{})
+		if doc.Lang != test.want {
+			t.Errorf("ParseDocument(%s).Lang = %q, want %q", test.html, doc.Lang, test.want)
+		}
+	}
+}
+
+func TestParseDocumentProtocolRelative(t *testing.T) {
+	host, _ := url.Parse("https://example.com/docs/intro")
+	page := `<html><body>
+<a href="//other.com/x">Other</a>
+<a href="//example.com/y">Same</a>
+<img src="//cdn.other.com/a.png">
+</body></html>`
+	doc := ParseDocument(host, strings.NewReader(page), ParseOptions{ExternalAssets: true, ExternalLinks: true})
+
+	if got := strings.Join(linkPaths(doc), " "); got != "/y" {
+		t.Errorf("links = %q, want %q", got, "/y")
+	}
+	if len(doc.ExternalLinks) != 1 || doc.ExternalLinks[0].String() != "https://other.com/x" {
+		t.Errorf("external links = %v, want https://other.com/x", doc.ExternalLinks)
+	}
+	want := Asset{URL: "https://cdn.other.com/a.png", External: true}
+	if len(doc.Assets) != 1 || doc.Assets[0] != want {
+		t.Errorf("assets = %v, want %v", doc.Assets, want)
+	}
+}
+
+func TestParseDocumentRelativeURLs(t *testing.T) {
+	host, _ := url.Parse("http://example.com/docs/intro")
+	page := `<html><head>
+<meta http-equiv="refresh" content="5; url=../moved">
+</head><body>
+<a href="page2">Relative</a>
+<a href="../up">Dot segment</a>
+<a href="./same/../here">Dots inside</a>
+<a href="?q=1">Query only</a>
+<iframe src="page2"></iframe>
+<form action="search"></form>
+<link rel="next" href="intro?page=2">
+</body></html>`
+	doc := ParseDocument(host, strings.NewReader(page), ParseOptions{})
+
+	var got []string
+	for _, link := range doc.Links {
+		got = append(got, link.RequestURI())
+	}
+	// Every visitor resolves the same way, so <a> and <iframe> agree on page2.
+	want := "/moved /docs/page2 /up /docs/here /docs/intro?q=1 /docs/page2 /docs/search /docs/intro?page=2"
+	if strings.Join(got, " ") != want {
+		t.Errorf("links = %q, want %q", strings.Join(got, " "), want)
+	}
+	if len(doc.Frames) != 1 || doc.Frames[0].Path != "/docs/page2" {
+		t.Errorf("frames = %v, want /docs/page2", doc.Frames)
+	}
+}
+
+func TestStreamDocument(t *testing.T) {
+	host, _ := url.Parse("http://example.com/docs/intro")
+	page := `<html lang="en"><head><title>Intro &amp; more</title>
+<link rel="stylesheet" href="/site.css"><link rel="next" href="intro?page=2">
+<script type="application/ld+json">{"@type": "Article"}</script>
+</head><body>
+<svg><title>Icon</title></svg>
+<a href="page2">Two</a><iframe src="/embed"></iframe>
+<form action="/search"></form>
+<noscript><a href="/fallback">Fallback</a></noscript>
+<img src="/logo.png">
+</body></html>`
+
+	// Streaming finds the same things the tree does, <noscript> content too.
+	tree := ParseDocument(host, strings.NewReader(page), ParseOptions{Noscript: true})
+	var links []string
+	var kinds []LinkKind
+	var assets []Asset
+	doc := ParseDocument(host, strings.NewReader(page), ParseOptions{
+		Noscript: true,
+		OnLink: func(link url.URL, kind LinkKind) {
+			links = append(links, link.RequestURI())
+			kinds = append(kinds, kind)
+		},
+		OnAsset: func(asset Asset) {
+			assets = append(assets, asset)
+		}})
+	var want []string
+	for _, link := range tree.Links {
+		want = append(want, link.RequestURI())
+	}
+	if got, want := strings.Join(links, " "), strings.Join(want, " "); got != want {
+		t.Errorf("streamed links = %q, want %q", got, want)
+	}
+	wantKinds := []LinkKind{LinkPagination, LinkPlain, LinkFrame, LinkForm, LinkPlain}
+	if !slices.Equal(kinds, wantKinds) {
+		t.Errorf("link kinds = %v, want %v", kinds, wantKinds)
+	}
+	if !slices.Equal(assets, tree.Assets) {
+		t.Errorf("streamed assets = %v, want %v", assets, tree.Assets)
+	}
+	if doc.Title != "Intro & more" || doc.Lang != "en" || len(doc.StructuredData) != 1 || len(doc.Forms) != 1 {
+		t.Errorf("streamed title %q, lang %q, %d JSON-LD blocks, %d forms, want %q, %q, 1, 1",
+			doc.Title, doc.Lang, len(doc.StructuredData), len(doc.Forms), tree.Title, tree.Lang)
+	}
+	if len(doc.Links) != 0 || len(doc.Assets) != 0 {
+		t.Errorf("streamed links and assets were collected too: %v, %v", doc.Links, doc.Assets)
+	}
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestStreamDocumentReadsIncrementally(t *testing.T) {
+	host, _ := url.Parse("http://example.com/")
+	page := `<a href="/first">First</a>` + strings.Repeat(`<p>filler</p>`, 100000)
+	body := &countingReader{r: strings.NewReader(page)}
+	readAtFirst := -1
+	ParseDocument(host, body, ParseOptions{OnLink: func(link url.URL, kind LinkKind) {
+		if readAtFirst < 0 {
+			readAtFirst = body.n
+		}
+	}})
+	// The first link turns up long before the rest of the page is read.
+	if readAtFirst < 0 || readAtFirst >= len(page)/2 {
+		t.Errorf("first link streamed after reading %d of %d bytes", readAtFirst, len(page))
+	}
+}
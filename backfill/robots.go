@@ -0,0 +1,183 @@
+package backfill
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// RobotsRules holds the Allow/Disallow rules from robots.txt that apply to us.
+type RobotsRules struct {
+	Allow    []string
+	Disallow []string
+}
+
+// ParseRobots parses a robots.txt file and keeps the rules for the group that
+// best matches agent, falling back to the "*" group. The best match is the
+// longest group name found in agent, so "googlebot-news" wins over "googlebot"
+// for "Googlebot-News/2.1".
+func ParseRobots(r io.Reader, agent string) *RobotsRules {
+	agent = strings.ToLower(agent)
+	groups := make(map[string]*RobotsRules)
+	var current []*RobotsRules
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "user-agent":
+			// Consecutive User-agent lines share one group of rules.
+			if !inAgents {
+				current = nil
+				inAgents = true
+			}
+			name := strings.ToLower(val)
+			if groups[name] == nil {
+				groups[name] = &RobotsRules{}
+			}
+			current = append(current, groups[name])
+		case "allow", "disallow":
+			inAgents = false
+			if val == "" {
+				continue
+			}
+			for _, rules := range current {
+				if key == "allow" {
+					rules.Allow = append(rules.Allow, val)
+				} else {
+					rules.Disallow = append(rules.Disallow, val)
+				}
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	best := ""
+	if agent != "" {
+		for name := range groups {
+			if name != "*" && strings.Contains(agent, name) && len(name) > len(best) {
+				best = name
+			}
+		}
+	}
+	if best != "" {
+		return groups[best]
+	}
+	if rules, ok := groups["*"]; ok {
+		return rules
+	}
+	return &RobotsRules{}
+}
+
+// Allowed determines if a path, with its query if any, may be crawled. The
+// longest matching rule wins, and Allow wins ties. See RobotsMatch for how rules
+// match.
+func (r *RobotsRules) Allowed(path string) bool {
+	allow, disallow := -1, -1
+	for _, pattern := range r.Allow {
+		if RobotsMatch(pattern, path) && len(pattern) > allow {
+			allow = len(pattern)
+		}
+	}
+	for _, pattern := range r.Disallow {
+		if RobotsMatch(pattern, path) && len(pattern) > disallow {
+			disallow = len(pattern)
+		}
+	}
+	return allow >= disallow
+}
+
+// RobotsMatch determines if a robots.txt rule matches path. Rules are path
+// prefixes, where "*" matches any run of characters and a trailing "$" anchors
+// the rule to the end of the path, e.g. "/*.php$" matches "/a/b.php" but not
+// "/b.php?x=1".
+func RobotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+
+	// Each part between stars matches as early as it can, which leaves the
+	// most room for the ones after it. An anchored last part has to be a
+	// suffix of what's left instead.
+	last := len(parts) - 1
+	for i := 1; i < last; i += 1 {
+		j := strings.Index(rest, parts[i])
+		if j < 0 {
+			return false
+		}
+		rest = rest[j+len(parts[i]):]
+	}
+	if anchored {
+		return strings.HasSuffix(rest, parts[last])
+	}
+	return strings.Contains(rest, parts[last])
+}
+
+// RobotsTag holds the indexing directives from X-Robots-Tag headers.
+type RobotsTag struct {
+	NoIndex  bool
+	NoFollow bool
+}
+
+// ParseRobotsTag parses the values of a response's X-Robots-Tag headers. Each
+// value is a comma-separated list of directives like "noindex, nofollow",
+// optionally prefixed with the agent it's for, as in "googlebot: noindex".
+// Directives for other agents are ignored; "none" means noindex and nofollow.
+func ParseRobotsTag(values []string, agent string) RobotsTag {
+	agent = strings.ToLower(agent)
+	var tag RobotsTag
+	for _, value := range values {
+		directives := value
+		// "unavailable_after: <date>" and friends also contain a colon, so
+		// only treat the prefix as an agent if it isn't a directive itself.
+		if name, rest, ok := strings.Cut(value, ":"); ok && !strings.Contains(name, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !isRobotsDirective(name) {
+				if name != "*" && !strings.Contains(agent, name) {
+					continue
+				}
+				directives = rest
+			}
+		}
+		for _, directive := range strings.Split(directives, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "noindex":
+				tag.NoIndex = true
+			case "nofollow":
+				tag.NoFollow = true
+			case "none":
+				tag.NoIndex = true
+				tag.NoFollow = true
+			}
+		}
+	}
+	return tag
+}
+
+// isRobotsDirective determines if name is a directive that takes a value.
+func isRobotsDirective(name string) bool {
+	switch name {
+	case "unavailable_after", "max-snippet", "max-image-preview", "max-video-preview":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package backfill
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRobotsGroup(t *testing.T) {
+	robots := `User-agent: *
+Disallow: /all
+
+User-agent: googlebot
+Disallow: /google
+
+User-agent: googlebot-news
+Disallow: /news
+`
+	tests := []struct {
+		agent string
+		want  string
+	}{
+		{"", "/all"},
+		{"docs-bot/1.0", "/all"},
+		{"Googlebot/2.1", "/google"},
+		{"Googlebot-News/2.1", "/news"},
+	}
+	for _, test := range tests {
+		// Map order used to pick between googlebot and googlebot-news, so
+		// parse a few times to catch it.
+		for i := 0; i < 10; i += 1 {
+			rules := ParseRobots(strings.NewReader(robots), test.agent)
+			if len(rules.Disallow) != 1 || rules.Disallow[0] != test.want {
+				t.Fatalf("ParseRobots(%q).Disallow = %v, want [%s]", test.agent, rules.Disallow, test.want)
+			}
+		}
+	}
+}
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/anything", true},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish", false},
+		{"/fish$", "/fish", true},
+		{"/fish$", "/fish/", false},
+		{"/*.php", "/index.php", true},
+		{"/*.php", "/a/b.php?x=1", true},
+		{"/*.php", "/index.html", false},
+		{"/*.php$", "/a/b.php", true},
+		{"/*.php$", "/b.php?x=1", false},
+		{"/*.php$", "/a.php.php", true},
+		{"/a*b*c", "/axxbyyc", true},
+		{"/a*b*c", "/axxcyyb", false},
+		{"/*?session=", "/page?session=1", true},
+		{"*", "/", true},
+	}
+	for _, test := range tests {
+		if got := RobotsMatch(test.pattern, test.path); got != test.want {
+			t.Errorf("RobotsMatch(%q, %q) = %v, want %v", test.pattern, test.path, got, test.want)
+		}
+	}
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	rules := &RobotsRules{
+		Allow:    []string{"/private/*.html$", "/page"},
+		Disallow: []string{"/private/", "/*?session=", "/page"}}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/public", true},
+		{"/private/data.json", false},
+		{"/private/index.html", true},
+		{"/private/index.html?x=1", false},
+		{"/search?session=1", false},
+		{"/page", true},
+	}
+	for _, test := range tests {
+		if got := rules.Allowed(test.path); got != test.want {
+			t.Errorf("Allowed(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package backfill
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// Sitemap holds the URLs declared in a sitemap.xml file. A sitemap index lists
+// other sitemaps instead of pages, in Sitemaps.
+type Sitemap struct {
+	URLs     []string
+	Sitemaps []string
+}
+
+// ParseSitemap parses a sitemap or sitemap index in the sitemaps.org format.
+func ParseSitemap(r io.Reader) (Sitemap, error) {
+	var doc struct {
+		XMLName xml.Name
+		URLs    []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+	var sitemap Sitemap
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return sitemap, err
+	}
+	for _, entry := range doc.URLs {
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			sitemap.URLs = append(sitemap.URLs, loc)
+		}
+	}
+	for _, entry := range doc.Sitemaps {
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			sitemap.Sitemaps = append(sitemap.Sitemaps, loc)
+		}
+	}
+	return sitemap, nil
+}
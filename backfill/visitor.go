@@ -0,0 +1,384 @@
+package backfill
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Visitor is called by ParseDocument for every element in a document. t is the
+// element as a start tag, for use with GetAttr and GetAttrURL, and n is its node
+// in the parsed tree for visitors that need its children. A visitor adds what it
+// finds through x.
+type Visitor func(x *Extraction, n *html.Node, t html.Token)
+
+// DefaultVisitors extract what ParseDocument always has: links, static assets,
+// forms, JSON-LD structured data, the title, the language, rel=next/prev
+// pagination, meta refresh redirects, frames, feeds and whatever the registered
+// Extractors pick out. Copy and append to it to add extractors.
+var DefaultVisitors = []Visitor{VisitLinks, VisitAssets, VisitExtractors, VisitForms, VisitStructuredData, VisitTitle,
+	VisitLang, VisitPagination, VisitMetaRefresh, VisitFrames, VisitFeeds}
+
+// Extraction is the document being parsed, as seen by a Visitor.
+type Extraction struct {
+	Host    *url.URL
+	Options ParseOptions
+	Doc     *Document
+}
+
+// SameHost determines if a link is on the document's host, after HostRewrite.
+func (x *Extraction) SameHost(link *url.URL) bool {
+	rewritten := RewriteHost(link, x.Options.HostRewrite)
+	return SameHost(x.Host, &rewritten)
+}
+
+// AddLink adds a link to follow, or passes it to OnLink if that is set. Links
+// with schemes that aren't allowed are passed to AddOtherLink instead.
+func (x *Extraction) AddLink(link url.URL) {
+	x.AddLinkAs(link, LinkPlain)
+}
+
+// AddLinkAs is AddLink for a link that OnLink should be told was found as kind.
+func (x *Extraction) AddLinkAs(link url.URL, kind LinkKind) {
+	if !SchemeAllowed(&link, x.Options.AllowedSchemes) {
+		x.AddOtherLink(link)
+		return
+	}
+	if x.Options.OnLink != nil {
+		x.Options.OnLink(link, kind)
+		return
+	}
+	x.Doc.Links = append(x.Doc.Links, link)
+}
+
+// AddEmbeddedLink resolves a URL found in a script or attribute against the
+// document and adds it to EmbeddedLinks if it is on the same host, or to
+// ExternalLinks if those are kept. Anything that isn't a crawlable URL is
+// ignored.
+func (x *Extraction) AddEmbeddedLink(raw string) {
+	if strings.TrimSpace(raw) == "" {
+		return
+	}
+	link, err := ResolveURL(x.Host, raw)
+	if err != nil {
+		return
+	}
+	if !SchemeAllowed(link, x.Options.AllowedSchemes) {
+		return
+	}
+	if x.SameHost(link) {
+		x.Doc.EmbeddedLinks = append(x.Doc.EmbeddedLinks, *link)
+	} else if x.Options.ExternalLinks {
+		x.Doc.ExternalLinks = append(x.Doc.ExternalLinks, *link)
+	}
+}
+
+// SortLink adds a link found on the document where it belongs: with AddLink if
+// it's on the same host, to ExternalLinks if it isn't and those are kept, or
+// with AddOtherLink if its scheme isn't crawled.
+func (x *Extraction) SortLink(link url.URL) {
+	// mailto: and friends have no host, so check them before scoping.
+	if !SchemeAllowed(&link, x.Options.AllowedSchemes) {
+		x.AddOtherLink(link)
+		return
+	}
+	if x.SameHost(&link) {
+		x.AddLink(link)
+	} else if x.Options.ExternalLinks {
+		x.Doc.ExternalLinks = append(x.Doc.ExternalLinks, link)
+	}
+}
+
+// AddOtherLink records a link with a scheme that isn't crawled, if the
+// OtherSchemes option is set.
+func (x *Extraction) AddOtherLink(link url.URL) {
+	if x.Options.OtherSchemes {
+		x.Doc.OtherLinks = append(x.Doc.OtherLinks, link)
+	}
+}
+
+// AddAsset adds a static asset, or passes it to OnAsset if that is set. Assets
+// on other hosts are dropped unless ExternalAssets is set, and so are assets
+// with schemes that aren't allowed, like inline data: images, and ones the
+// AssetExtensions filter leaves out.
+func (x *Extraction) AddAsset(link *url.URL) {
+	if !SchemeAllowed(link, x.Options.AllowedSchemes) || !x.Options.AssetExtensions.Allows(link) {
+		return
+	}
+	external := !x.SameHost(link)
+	if external && !x.Options.ExternalAssets {
+		return
+	}
+	asset := Asset{link.String(), external}
+	if x.Options.OnAsset != nil {
+		x.Options.OnAsset(asset)
+		return
+	}
+	x.Doc.Assets = append(x.Doc.Assets, asset)
+}
+
+// Logf logs a warning about the document through the Logf option.
+func (x *Extraction) Logf(format string, v ...any) {
+	if x.Options.Logf != nil {
+		x.Options.Logf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// VisitLinks adds the same-host links from <a href>.
+func VisitLinks(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.A {
+		return
+	}
+	href, err := GetAttrURL(x.Host, t, "href")
+	if err != nil || len(href.String()) == 0 {
+		return
+	}
+	x.SortLink(*href)
+}
+
+// VisitAssets adds stylesheets and other resources from <link href>.
+// Pagination <link>s are left to VisitPagination and feeds to VisitFeeds.
+// Images and scripts are picked up by DefaultExtractors.
+func VisitAssets(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.Link {
+		return
+	}
+	// rel=next/prev point at pages, not resources to load.
+	if HasRel(t, "next") || HasRel(t, "prev") || HasRel(t, "previous") || LinkFeed(t) != "" {
+		return
+	}
+	link, err := GetAttrURL(x.Host, t, "href")
+	if err == nil && link.String() != "" {
+		x.AddAsset(link)
+	}
+}
+
+// VisitForms records every <form>. Same-host GET forms are also followed as
+// links; POSTs are recorded but never sent.
+func VisitForms(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.Form {
+		return
+	}
+	form := FormInfo{Action: *x.Host, Method: http.MethodGet}
+	// A missing or empty action submits back to the page itself.
+	if action, err := GetAttrURL(x.Host, t, "action"); err == nil && action.String() != "" {
+		form.Action = *action
+	}
+	if method, err := GetAttr(t, "method"); err == nil && method != "" {
+		form.Method = strings.ToUpper(method)
+	}
+	x.Doc.Forms = append(x.Doc.Forms, form)
+
+	if form.Method == http.MethodGet && x.SameHost(&form.Action) {
+		x.AddLinkAs(form.Action, LinkForm)
+	}
+}
+
+// VisitStructuredData adds the JSON-LD blocks from <script
+// type="application/ld+json">, skipping any that aren't valid JSON.
+func VisitStructuredData(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.Script {
+		return
+	}
+	if kind, _ := GetAttr(t, "type"); !IsJSONLD(kind) {
+		return
+	}
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
+		}
+	}
+	data := []byte(strings.TrimSpace(text.String()))
+	if !json.Valid(data) {
+		x.Logf("skipping invalid JSON-LD on %s\n", x.Host.String())
+		return
+	}
+	x.Doc.StructuredData = append(x.Doc.StructuredData, json.RawMessage(data))
+}
+
+// VisitTitle records the text of the document's first <title>. Titles inside
+// inline SVG belong to the drawing, not the page, so they're skipped.
+func VisitTitle(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.Title || n.Namespace != "" || x.Doc.Title != "" {
+		return
+	}
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
+		}
+	}
+	x.Doc.Title = strings.Join(strings.Fields(text.String()), " ")
+}
+
+// VisitLang records the lang attribute of the <html> element as the document's
+// language, exactly as declared, region subtag and all (e.g. "en-US").
+func VisitLang(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.Html {
+		return
+	}
+	if lang, err := GetAttr(t, "lang"); err == nil {
+		x.Doc.Lang = strings.TrimSpace(lang)
+	}
+}
+
+// VisitPagination follows same-host <link rel="next"> and <link rel="prev">
+// links and records them as the document's NextPage and PrevPage, so paginated
+// listings are covered even when the visible pager needs JavaScript.
+func VisitPagination(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.Link {
+		return
+	}
+	var target *url.URL
+	switch {
+	case HasRel(t, "next"):
+		target = &x.Doc.NextPage
+	case HasRel(t, "prev"), HasRel(t, "previous"):
+		target = &x.Doc.PrevPage
+	default:
+		return
+	}
+	href, err := GetAttrURL(x.Host, t, "href")
+	if err != nil || href.String() == "" || !x.SameHost(href) || target.String() != "" {
+		return
+	}
+	*target = *href
+	x.AddLinkAs(*href, LinkPagination)
+}
+
+// VisitMetaRefresh records the target of the document's first <meta
+// http-equiv="refresh"> redirect as its RefreshURL, and follows it like a link
+// if it's on the same host.
+func VisitMetaRefresh(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.Meta || x.Doc.RefreshURL.String() != "" {
+		return
+	}
+	if equiv, _ := GetAttr(t, "http-equiv"); !strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+		return
+	}
+	content, _ := GetAttr(t, "content")
+	raw, ok := ParseRefresh(content)
+	if !ok {
+		return
+	}
+	link, err := ResolveURL(x.Host, raw)
+	if err != nil {
+		x.Logf("skipping bad meta refresh URL %q on %s\n", raw, x.Host.String())
+		return
+	}
+	x.Doc.RefreshURL = *link
+	if !SchemeAllowed(link, x.Options.AllowedSchemes) {
+		x.AddOtherLink(*link)
+	} else if x.SameHost(link) {
+		x.AddLink(*link)
+	} else if x.Options.ExternalLinks {
+		x.Doc.ExternalLinks = append(x.Doc.ExternalLinks, *link)
+	}
+}
+
+// VisitFrames follows the src of <iframe> and <frame> elements like a link,
+// recording the same-host ones in Frames.
+func VisitFrames(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.Iframe && t.DataAtom != atom.Frame {
+		return
+	}
+	src, err := GetAttr(t, "src")
+	if err != nil || strings.TrimSpace(src) == "" {
+		return
+	}
+	link, err := ResolveURL(x.Host, src)
+	if err != nil {
+		x.Logf("skipping bad frame URL %q on %s\n", src, x.Host.String())
+		return
+	}
+	if SchemeAllowed(link, x.Options.AllowedSchemes) && x.SameHost(link) {
+		x.Doc.Frames = append(x.Doc.Frames, *link)
+		x.AddLinkAs(*link, LinkFrame)
+		return
+	}
+	x.SortLink(*link)
+}
+
+// VisitFeeds records the feeds and OpenSearch descriptions a document links to,
+// once each.
+func VisitFeeds(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.Link {
+		return
+	}
+	kind := LinkFeed(t)
+	if kind == "" {
+		return
+	}
+	href, err := GetAttrURL(x.Host, t, "href")
+	if err != nil || href.String() == "" || !SchemeAllowed(href, x.Options.AllowedSchemes) {
+		return
+	}
+	for _, feed := range x.Doc.Feeds {
+		if feed.URL == href.String() {
+			return
+		}
+	}
+	x.Doc.Feeds = append(x.Doc.Feeds, Feed{URL: href.String(), Kind: kind})
+}
+
+// VisitJSONLDLinks is an opt-in Visitor that follows the "url" and "@id" values
+// in JSON-LD blocks, adding them to the document's EmbeddedLinks. Structured
+// data often names pages nothing else links to, but also plenty that aren't
+// pages, so it isn't one of the DefaultVisitors.
+func VisitJSONLDLinks(x *Extraction, n *html.Node, t html.Token) {
+	if t.DataAtom != atom.Script {
+		return
+	}
+	if kind, _ := GetAttr(t, "type"); !IsJSONLD(kind) {
+		return
+	}
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
+		}
+	}
+	var data any
+	if json.Unmarshal([]byte(text.String()), &data) != nil {
+		return
+	}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for key, val := range v {
+				if raw, ok := val.(string); ok && (key == "url" || key == "@id") {
+					x.AddEmbeddedLink(raw)
+				}
+				walk(val)
+			}
+		case []any:
+			for _, val := range v {
+				walk(val)
+			}
+		}
+	}
+	walk(data)
+}
+
+// DataAttributeLinks builds an opt-in Visitor that follows URLs in the given
+// attributes of any element, e.g. DataAttributeLinks("data-href", "data-url")
+// for sites that navigate with JavaScript. They're added to the document's
+// EmbeddedLinks.
+func DataAttributeLinks(attrs ...string) Visitor {
+	return func(x *Extraction, n *html.Node, t html.Token) {
+		for _, attr := range attrs {
+			if raw, err := GetAttr(t, attr); err == nil {
+				x.AddEmbeddedLink(raw)
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FrontierCheckpoint is the progress of a crawl as saved to
+// CrawlerConfig.FrontierPath: the links queued but not yet crawled, and how
+// every page key ever queued was found. Pending includes links a RequestWorker
+// had picked up but the IndexWorker hadn't finished with, since their links
+// would be lost otherwise.
+type FrontierCheckpoint struct {
+	Domain  string               `json:"domain"`
+	Pending []string             `json:"pending"`
+	Visited map[string]Discovery `json:"visited"`
+}
+
+// LoadFrontier reads a checkpoint saved by SaveFrontier. The error wraps
+// os.ErrNotExist if there is no file.
+func LoadFrontier(path string) (FrontierCheckpoint, error) {
+	var checkpoint FrontierCheckpoint
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return checkpoint, err
+	}
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("bad frontier file %s: %w", path, err)
+	}
+	return checkpoint, nil
+}
+
+// SaveFrontier writes a checkpoint to path. It writes a temporary file next to
+// it and renames it into place, so a crash mid-write leaves the last checkpoint
+// intact.
+func SaveFrontier(path string, checkpoint FrontierCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Checkpoint gets the crawl's progress for FrontierPath. Pending links are
+// sorted so successive checkpoints diff cleanly. The caller must hold the
+// site's lock.
+func (state *CrawlerState) Checkpoint() FrontierCheckpoint {
+	checkpoint := FrontierCheckpoint{
+		Domain:  state.Site.Domain.String(),
+		Pending: make([]string, 0, len(state.pending)),
+		Visited: make(map[string]Discovery, len(state.visited))}
+	for _, link := range state.pending {
+		checkpoint.Pending = append(checkpoint.Pending, link.String())
+	}
+	sort.Strings(checkpoint.Pending)
+	for key, how := range state.visited {
+		checkpoint.Visited[key] = how
+	}
+	return checkpoint
+}
+
+// Resume loads the crawl's progress from FrontierPath and queues its pending
+// links. It determines if there was a checkpoint to resume from; if not, the
+// crawl starts from its seeds. A checkpoint of another site is an error, so one
+// crawl's progress is never overwritten by another's.
+func (state *CrawlerState) Resume() (bool, error) {
+	path := state.Config.FrontierPath
+	checkpoint, err := LoadFrontier(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if checkpoint.Domain != state.Site.Domain.String() {
+		return false, fmt.Errorf("frontier file %s is for %s, not %s", path, checkpoint.Domain, state.Site.Domain.String())
+	}
+
+	state.Site.mu.Lock()
+	defer state.Site.mu.Unlock()
+	for key, how := range checkpoint.Visited {
+		state.visited[key] = how
+	}
+	for _, raw := range checkpoint.Pending {
+		link, err := url.Parse(raw)
+		if err != nil {
+			state.Config.Logf(LogError, "skipping bad link in %s: %s\n", path, err)
+			continue
+		}
+		state.pending[state.Site.PageKey(link)] = *link
+		state.Links.Push(*link)
+	}
+	state.Config.Logf(LogInfo, "resuming crawl from %s: %d links pending, %d queued before\n",
+		path, len(checkpoint.Pending), len(checkpoint.Visited))
+	return true, nil
+}
+
+// FlushFrontier saves the crawl's progress to FrontierPath every FrontierFlush
+// until the crawl is over. Failing to save is logged but doesn't stop the
+// crawl. There should only be ONE FlushFrontier goroutine.
+func FlushFrontier(state *CrawlerState) {
+	for {
+		select {
+		case <-state.Done:
+			return
+		case <-state.RequestsDone:
+			return
+		case <-time.After(state.Config.FrontierFlush):
+			state.SaveFrontier()
+		}
+	}
+}
+
+// SaveFrontier saves the crawl's progress to FrontierPath, logging any failure.
+func (state *CrawlerState) SaveFrontier() {
+	state.Site.mu.RLock()
+	checkpoint := state.Checkpoint()
+	state.Site.mu.RUnlock()
+	if err := SaveFrontier(state.Config.FrontierPath, checkpoint); err != nil {
+		state.Config.Logf(LogError, "can't save the frontier: %s\n", err)
+		return
+	}
+	state.Config.Logf(LogDebug, "saved %d pending links to %s\n", len(checkpoint.Pending), state.Config.FrontierPath)
+}
+
+// settle marks a queued link as finished with, so it's no longer saved as
+// pending. The caller must hold the site's lock.
+func (state *CrawlerState) settle(link *url.URL) {
+	if state.pending != nil {
+		delete(state.pending, state.Site.PageKey(link))
+	}
+}
@@ -0,0 +1,157 @@
+package crawler
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	"crawler/backfill"
+)
+
+// NewClient builds the http.Client shared by every request in a crawl from the
+// transport options in the config.
+func NewClient(config *CrawlerConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.DialContext != nil {
+		transport.DialContext = config.DialContext
+	} else if config.Resolver != nil {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Resolver:  config.Resolver}
+		transport.DialContext = dialer.DialContext
+	}
+	if config.TLSConfig != nil {
+		transport.TLSClientConfig = config.TLSConfig.Clone()
+	}
+	if config.InsecureSkipVerify {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+	if config.Proxy != nil {
+		transport.Proxy = http.ProxyURL(config.Proxy)
+	}
+	if config.ProxyHeaders != nil {
+		transport.ProxyConnectHeader = config.ProxyHeaders.Clone()
+	}
+	if config.ForceHTTP1 {
+		// A non-nil, empty TLSNextProto is how net/http is told not to upgrade to h2.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	// A login flow needs somewhere to keep the session cookie it sets up.
+	jar := config.Jar
+	if jar == nil && config.Login != nil {
+		jar, _ = cookiejar.New(nil)
+	}
+	var roundTripper http.RoundTripper = transport
+	if config.ProxyHeaders != nil {
+		roundTripper = &proxyHeaderTransport{transport, config.ProxyHeaders}
+	}
+	return &http.Client{Transport: roundTripper, Jar: jar, CheckRedirect: CheckRedirect(config.MaxRedirects, config.RedirectPolicy)}
+}
+
+// proxyHeaderTransport adds the Proxy-Authorization from the ProxyHeaders to
+// plain http requests that go through a proxy. Any other header would be
+// forwarded to the site. Requests to https sites get all of them on the CONNECT
+// instead, through the transport's ProxyConnectHeader.
+type proxyHeaderTransport struct {
+	*http.Transport
+	headers http.Header
+}
+
+func (t *proxyHeaderTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.URL.Scheme != "http" || t.Proxy == nil {
+		return t.Transport.RoundTrip(request)
+	}
+	if proxy, err := t.Proxy(request); err != nil || proxy == nil {
+		return t.Transport.RoundTrip(request)
+	}
+	auth := t.headers.Values("Proxy-Authorization")
+	if len(auth) == 0 {
+		return t.Transport.RoundTrip(request)
+	}
+	request = request.Clone(request.Context())
+	request.Header["Proxy-Authorization"] = auth
+	return t.Transport.RoundTrip(request)
+}
+
+// RedirectError is returned when a request is redirected too many times or back
+// to a URL it already visited. Chain is every URL requested, in order, ending
+// with the redirect that wasn't followed.
+type RedirectError struct {
+	Chain []url.URL
+	Loop  bool
+}
+
+func (e *RedirectError) Error() string {
+	hops := make([]string, len(e.Chain))
+	for i, link := range e.Chain {
+		hops[i] = link.String()
+	}
+	if e.Loop {
+		return "redirect loop: " + strings.Join(hops, " -> ")
+	}
+	return fmt.Sprintf("stopped after %d redirects: %s", len(e.Chain)-1, strings.Join(hops, " -> "))
+}
+
+// RedirectPolicy decides which redirects the client follows. Redirects that
+// aren't followed are recorded as pages with their 3xx status and where they
+// lead, see Webpage.RedirectURL.
+type RedirectPolicy int
+
+const (
+	// FollowAllRedirects follows redirects anywhere, up to MaxRedirects.
+	FollowAllRedirects RedirectPolicy = iota
+	// FollowSameHostRedirects only follows redirects that stay on the host
+	// the request started on.
+	FollowSameHostRedirects
+	// FollowNoRedirects never follows redirects.
+	FollowNoRedirects
+)
+
+// CheckRedirect builds an http.Client CheckRedirect function that follows the
+// redirects the policy allows. It fails with a RedirectError once a request has
+// been redirected max times or a redirect leads back to a URL already visited.
+// A max of zero means MaxRedirects.
+func CheckRedirect(max int, policy RedirectPolicy) func(*http.Request, []*http.Request) error {
+	if max <= 0 {
+		max = MaxRedirects
+	}
+	return func(request *http.Request, via []*http.Request) error {
+		switch {
+		case policy == FollowNoRedirects:
+			return http.ErrUseLastResponse
+		case policy == FollowSameHostRedirects && !backfill.SameHost(request.URL, via[0].URL):
+			return http.ErrUseLastResponse
+		}
+		chain := make([]url.URL, 0, len(via)+1)
+		loop := false
+		for _, previous := range via {
+			chain = append(chain, *previous.URL)
+			loop = loop || previous.URL.String() == request.URL.String()
+		}
+		chain = append(chain, *request.URL)
+		if loop || len(via) >= max {
+			return &RedirectError{Chain: chain, Loop: loop}
+		}
+		return nil
+	}
+}
+
+// HTTPClient gets the client to make requests with, building it with NewClient
+// the first time if the config doesn't already have one.
+func (c *CrawlerConfig) HTTPClient() *http.Client {
+	if c.Client == nil {
+		c.Client = NewClient(c)
+	}
+	return c.Client
+}
@@ -0,0 +1,201 @@
+package crawler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"crawler/testutil"
+)
+
+// clientCertificate makes a self-signed client certificate and a pool that
+// trusts it, for a server that requires mutual TLS.
+func clientCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "crawler test client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// robotsFixture is a site whose robots.txt keeps crawlers out of /private, so
+// /private only stays uncrawled if robots.txt was fetched successfully.
+func robotsFixture() map[string]string {
+	return map[string]string{
+		"/robots.txt": "User-agent: *\nDisallow: /private\n",
+		"/":           `<a href="/public">Public</a><a href="/private">Private</a>`,
+		"/public":     `<a href="/">Home</a>`,
+		"/private":    `<a href="/">Home</a>`}
+}
+
+// checkRobotsFixture checks a crawl of robotsFixture, as seen by the site and
+// by the server.
+func checkRobotsFixture(t *testing.T, site *Website, log *testutil.RequestLog) {
+	t.Helper()
+	if len(site.Errors) > 0 {
+		t.Fatalf("crawl failed: %v", site.Errors)
+	}
+	if _, ok := site.Pages["/public"]; !ok {
+		t.Errorf("pages = %v, want /public crawled", pageKeys(site))
+	}
+	if !log.Requested("/robots.txt") {
+		t.Errorf("requests = %v, want robots.txt fetched", log.Paths())
+	}
+	if log.Requested("/private") {
+		t.Errorf("requests = %v, want /private left alone by robots.txt", log.Paths())
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	cert, clients := clientCertificate(t)
+	var log testutil.RequestLog
+	server := httptest.NewUnstartedServer(log.Wrap(testutil.FixtureHandler(robotsFixture())))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clients}
+	server.StartTLS()
+	defer server.Close()
+
+	// Without the client certificate robots.txt can't be fetched, and the
+	// crawl would go on as if the site had none.
+	servers := x509.NewCertPool()
+	servers.AddCert(server.Certificate())
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: servers}
+	site := CrawlerWithConfig(testutil.SeedURL(server), config)
+	checkRobotsFixture(t, site, &log)
+}
+
+func TestPinnedDialer(t *testing.T) {
+	var log testutil.RequestLog
+	server := httptest.NewServer(log.Wrap(testutil.FixtureHandler(robotsFixture())))
+	defer server.Close()
+
+	// example.test doesn't resolve, so every request, robots.txt included, has
+	// to go through the pinned dialer to get anywhere.
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.DialContext = testutil.PinnedDialer(server)
+	site := CrawlerWithConfig(url.URL{Scheme: "http", Host: "example.test", Path: "/"}, config)
+	checkRobotsFixture(t, site, &log)
+}
+
+func TestProxyHeaders(t *testing.T) {
+	// The server stands in for a forward proxy that turns away requests
+	// without its credentials, so robots.txt is only found through the proxy.
+	// It passes every other header on, so the token must never reach it.
+	var log testutil.RequestLog
+	site := testutil.FixtureHandler(robotsFixture())
+	proxy := httptest.NewServer(log.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Bearer secret" {
+			http.Error(w, "missing proxy credentials", http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Header.Get("X-Proxy-Token") != "" {
+			http.Error(w, "proxy token forwarded to the site", http.StatusBadRequest)
+			return
+		}
+		site.ServeHTTP(w, r)
+	})))
+	defer proxy.Close()
+
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.Proxy, _ = url.Parse(proxy.URL)
+	config.ProxyHeaders = http.Header{"Proxy-Authorization": {"Bearer secret"}, "X-Proxy-Token": {"secret"}}
+	checkRobotsFixture(t, CrawlerWithConfig(url.URL{Scheme: "http", Host: "example.test", Path: "/"}, config), &log)
+}
+
+func TestProxyHeadersHTTPS(t *testing.T) {
+	var mu sync.Mutex
+	var seen []http.Header
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Clone())
+		mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	// A CONNECT-only forward proxy that tunnels to the origin once it has
+	// seen its token.
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Header.Get("X-Proxy-Token") != "secret" {
+			http.Error(w, "missing proxy token", http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer proxy.Close()
+
+	config := DefaultConfig()
+	config.InsecureSkipVerify = true
+	config.Proxy, _ = url.Parse(proxy.URL)
+	config.ProxyHeaders = http.Header{"Proxy-Authorization": {"Bearer secret"}, "X-Proxy-Token": {"secret"}}
+	response, err := NewClient(&config).Get(origin.URL)
+	if err != nil {
+		t.Fatalf("request through the proxy failed: %s", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %s, want the proxy to let the CONNECT through", response.Status)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 1 {
+		t.Fatalf("origin saw %d requests, want 1", len(seen))
+	}
+	for key := range config.ProxyHeaders {
+		if seen[0].Get(key) != "" {
+			t.Errorf("origin saw %s, want it only on the CONNECT", key)
+		}
+	}
+}
@@ -1,41 +1,190 @@
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	_ "net/http/pprof"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"crawler"
 )
 
 func PrintStaticAssets(site *crawler.Website) {
 	fmt.Printf("%s:\n", site.Domain.String())
-	for link, page := range site.Pages {
+	for link, page := range site.AllPages {
 		fmt.Printf("\t%s\n", link)
 
 		fmt.Printf("\tLINKS\n")
 		if len(page.Links) > 0 {
 			for _, link := range page.Links {
-				fmt.Printf("\t\t%s\n", link.String())
+				fmt.Printf("\t\t%s\n", site.FormatURL(&link))
 			}
 		} else {
 			fmt.Printf("\t\tN/A (no external links found)\n")
 		}
 
+		var local, external []crawler.Asset
+		for _, asset := range page.Assets {
+			if asset.External {
+				external = append(external, asset)
+			} else {
+				local = append(local, asset)
+			}
+		}
+
 		fmt.Printf("\tASSETS\n")
-		if len(page.Assets) > 0 {
-			for _, asset := range page.Assets {
-				fmt.Printf("\t\t%s\n", asset)
+		if len(local) > 0 {
+			for _, asset := range local {
+				fmt.Printf("\t\t%s\n", asset.URL)
 			}
 		} else {
 			fmt.Printf("\t\tN/A (assets may be inlined)\n")
 		}
+
+		if len(external) > 0 {
+			fmt.Printf("\tEXTERNAL ASSETS\n")
+			for _, asset := range external {
+				fmt.Printf("\t\t%s\n", asset.URL)
+			}
+		}
 		fmt.Printf("\n")
 	}
 }
 
+func PrintErrors(site *crawler.Website) {
+	if len(site.Errors) == 0 {
+		return
+	}
+	fmt.Printf("ERRORS\n")
+	for _, pageErr := range site.Errors {
+		fmt.Printf("\t%s\n", pageErr.Error())
+	}
+}
+
+func PrintSkipped(site *crawler.Website) {
+	if len(site.Skipped) == 0 {
+		return
+	}
+	fmt.Printf("SKIPPED\n")
+	for _, skip := range site.Skipped {
+		fmt.Printf("\t%s (%s)\n", skip.URL, skip.Reason)
+	}
+}
+
+func PrintBrokenLinks(site *crawler.Website) {
+	broken := site.BrokenLinks()
+	if len(broken) == 0 {
+		return
+	}
+	fmt.Printf("BROKEN LINKS\n")
+	for _, link := range broken {
+		fmt.Printf("\t%s (%d)\n", site.FormatURL(&link.URL), link.StatusCode)
+		for _, referrer := range link.Referrers {
+			fmt.Printf("\t\tlinked from %s\n", referrer)
+		}
+	}
+}
+
+func PrintBrokenAssets(site *crawler.Website) {
+	broken := site.BrokenAssets()
+	if len(broken) == 0 {
+		return
+	}
+	fmt.Printf("BROKEN ASSETS\n")
+	for _, check := range broken {
+		if check.Err != "" {
+			fmt.Printf("\t%s (%s)\n", check.URL, check.Err)
+		} else {
+			fmt.Printf("\t%s (%d)\n", check.URL, check.StatusCode)
+		}
+	}
+}
+
+func PrintMixedContent(site *crawler.Website) {
+	if len(site.MixedContent) == 0 {
+		return
+	}
+	fmt.Printf("MIXED CONTENT\n")
+	for _, issue := range site.MixedContent {
+		fmt.Printf("\t%s loads %s\n", site.FormatURL(&issue.Page), issue.Asset)
+	}
+}
+
+func PrintFeeds(site *crawler.Website) {
+	if len(site.Feeds) == 0 {
+		return
+	}
+	fmt.Printf("FEEDS\n")
+	for _, feed := range site.Feeds {
+		fmt.Printf("\t%s (%s)\n", feed.URL, feed.Kind)
+	}
+}
+
+func PrintAssetHosts(site *crawler.Website) {
+	counts := site.AssetHosts()
+	if len(counts) == 0 {
+		return
+	}
+	hosts := make([]string, 0, len(counts))
+	for host := range counts {
+		hosts = append(hosts, host)
+	}
+	// Biggest third parties first.
+	sort.Slice(hosts, func(i, j int) bool {
+		if counts[hosts[i]] != counts[hosts[j]] {
+			return counts[hosts[i]] > counts[hosts[j]]
+		}
+		return hosts[i] < hosts[j]
+	})
+
+	fmt.Printf("THIRD-PARTY ASSET HOSTS\n")
+	for _, host := range hosts {
+		fmt.Printf("\t%s (%d)\n", host, counts[host])
+	}
+}
+
+func PrintSlowestPages(site *crawler.Website) {
+	pages := site.SlowestPages(5)
+	if len(pages) == 0 {
+		return
+	}
+	fmt.Printf("SLOWEST PAGES\n")
+	for _, page := range pages {
+		fmt.Printf("\t%s (%s)\n", site.FormatURL(&page.URL), page.FetchDuration.Round(time.Millisecond))
+	}
+}
+
+// ReadSeeds reads seed URLs, one per line. Blank lines and lines starting with
+// # are skipped, and malformed URLs are logged and left out.
+func ReadSeeds(r io.Reader) ([]url.URL, error) {
+	var seeds []url.URL
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		seed, err := crawler.ParseSeed(text)
+		if err != nil {
+			log.Printf("skipping seed on line %d: %s\n", line, err)
+			continue
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds, scanner.Err()
+}
+
 func main() {
 	// Handle errors
 	defer func() {
@@ -44,22 +193,145 @@ func main() {
 		}
 	}()
 
-	if len(os.Args) != 2 {
-		fmt.Printf("Usage: ./%s [url]\n", os.Args[0])
+	config := crawler.DefaultConfig()
+	flag.DurationVar(&config.DebounceTimeout, "debounce", config.DebounceTimeout,
+		"how long all workers must be free before the crawl ends")
+	flag.DurationVar(&config.MaxIdle, "max-idle", config.MaxIdle,
+		"end the crawl early once nothing has been queued for this long (0 disables)")
+	flag.StringVar(&config.UserAgent, "user-agent", "", "User-Agent header to send")
+	flag.BoolVar(&config.IncludeExternalAssets, "external-assets", false,
+		"also list assets served from other hosts")
+	flag.BoolVar(&config.ValidateAssets, "check-assets", false,
+		"request every asset and report the broken ones")
+	verifySitemap := flag.Bool("verify-sitemap", false,
+		"treat the URL as a sitemap.xml and only fetch the URLs it declares")
+	seedsFile := flag.String("seeds", "",
+		"read seed URLs from this file, one per line, instead of taking a URL (- reads stdin)")
+	quiet := flag.Bool("quiet", false, "only log errors")
+	verbose := flag.Bool("verbose", false, "log every page requested and indexed")
+	certFile := flag.String("cert", "", "client certificate to present, for mutual TLS (needs -key)")
+	keyFile := flag.String("key", "", "private key for -cert")
+	flag.BoolVar(&config.InsecureSkipVerify, "insecure", false,
+		"accept any server certificate, e.g. self-signed staging servers")
+	proxy := flag.String("proxy", "",
+		"forward proxy to send requests through, with any credentials as userinfo (default from HTTP_PROXY/HTTPS_PROXY)")
+	relative := flag.Bool("relative", false, "print paths relative to the site instead of absolute URLs")
+	format := flag.String("format", "text", "output format: text, urls or csv")
+	debugAddr := flag.String("http", "",
+		"address to serve pprof, /metrics and /stats on while crawling, e.g. localhost:6060")
+	flag.Usage = func() {
+		fmt.Printf("Usage: ./%s [flags] [url]\n       ./%s [flags] -seeds file\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if (*seedsFile == "" && flag.NArg() != 1) || (*seedsFile != "" && flag.NArg() != 0) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *seedsFile != "" && *verifySitemap {
+		fmt.Println("Only one of -seeds and -verify-sitemap can be set.")
+		os.Exit(1)
+	}
+	if *quiet && *verbose {
+		fmt.Println("Only one of -quiet and -verbose can be set.")
+		os.Exit(1)
+	}
+	if *quiet {
+		config.LogLevel = crawler.LogError
+	} else if *verbose {
+		config.LogLevel = crawler.LogDebug
+	}
+	if *format != "text" && *format != "urls" && *format != "csv" {
+		fmt.Printf("Unknown format %q.\n", *format)
 		os.Exit(1)
 	}
 
-	link := os.Args[1]
-	if !strings.HasPrefix(link, "http") {
-		link = "http://" + link
+	var seeds []url.URL
+	var err error
+	if *seedsFile != "" {
+		input := os.Stdin
+		if *seedsFile != "-" {
+			if input, err = os.Open(*seedsFile); err != nil {
+				fmt.Printf("Error! %s.\n", err)
+				os.Exit(2)
+			}
+			defer input.Close()
+		}
+		if seeds, err = ReadSeeds(input); err != nil {
+			fmt.Printf("Error! Can't read seeds: %s.\n", err)
+			os.Exit(2)
+		}
+		if len(seeds) == 0 {
+			fmt.Printf("Error! No valid seeds in %s.\n", *seedsFile)
+			os.Exit(2)
+		}
+	} else {
+		var link url.URL
+		if link, err = crawler.ParseSeed(flag.Arg(0)); err != nil {
+			fmt.Printf("Error! %s.\n", err)
+			os.Exit(2)
+		}
+		seeds = []url.URL{link}
 	}
 
-	u, err := url.Parse(link)
-	if err != nil {
-		fmt.Println("Error! Malformed URL.")
-		os.Exit(2)
+	if *certFile != "" || *keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			fmt.Printf("Error! Can't load client certificate: %s.\n", err)
+			os.Exit(2)
+		}
+		config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
 	}
 
-	site := crawler.Crawler(*u)
-	PrintStaticAssets(site)
+	if *proxy != "" {
+		proxyURL, err := url.Parse(*proxy)
+		if err != nil || proxyURL.Host == "" {
+			fmt.Printf("Error! Bad proxy URL %q.\n", *proxy)
+			os.Exit(2)
+		}
+		config.Proxy = proxyURL
+	}
+
+	config.Stats = &crawler.CrawlStats{}
+	if *debugAddr != "" {
+		http.Handle("/metrics", crawler.MetricsHandler(config.Stats))
+		http.Handle("/stats", crawler.StatsHandler(config.Stats))
+		go func() {
+			log.Println(http.ListenAndServe(*debugAddr, nil))
+		}()
+	}
+
+	var site *crawler.Website
+	if *verifySitemap {
+		site = crawler.VerifySitemap(seeds[0], config)
+	} else {
+		site = crawler.CrawlerSeedsWithConfig(seeds, config)
+	}
+	if site.Err != nil {
+		log.Println(site.Err)
+	}
+	site.RelativeURLs = *relative
+	switch *format {
+	case "urls":
+		err = site.WriteURLs(os.Stdout)
+	case "csv":
+		err = site.WriteCSV(os.Stdout)
+	default:
+		PrintStaticAssets(site)
+		PrintAssetHosts(site)
+		PrintFeeds(site)
+		PrintSlowestPages(site)
+		PrintErrors(site)
+		if *verifySitemap {
+			// Declared URLs that weren't verified are part of the report.
+			PrintSkipped(site)
+		}
+		PrintBrokenLinks(site)
+		PrintBrokenAssets(site)
+		PrintMixedContent(site)
+	}
+	if err != nil {
+		log.Println(err)
+	}
 }
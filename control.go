@@ -0,0 +1,155 @@
+package crawler
+
+import (
+	"context"
+	"net/url"
+	"sync"
+)
+
+// Crawl is a crawl running in the background, started by StartCrawler. Stats is
+// updated as it goes.
+type Crawl struct {
+	Stats *CrawlStats
+
+	gate   *Gate
+	cancel context.CancelFunc
+	done   chan struct{}
+	mu     sync.Mutex
+	site   *Website
+}
+
+// StartCrawler starts crawling a site with the passed configuration and returns
+// right away with a handle to pause, resume, cancel, inspect and wait for the crawl.
+func StartCrawler(link url.URL, config CrawlerConfig) *Crawl {
+	ctx, cancel := context.WithCancel(context.Background())
+	if config.Stats == nil {
+		config.Stats = &CrawlStats{}
+	}
+	c := &Crawl{
+		Stats:  config.Stats,
+		gate:   &Gate{},
+		cancel: cancel,
+		done:   make(chan struct{})}
+	go func() {
+		defer cancel()
+		site := crawl(ctx, []url.URL{link}, config, nil, c)
+		// The site is already set unless the crawl had no seeds.
+		c.setSite(site)
+		close(c.done)
+	}()
+	return c
+}
+
+// setSite records the website the crawl is building.
+func (c *Crawl) setSite(site *Website) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.site = site
+}
+
+// Pause stops the crawl from starting any new requests. Requests already in
+// flight finish and are indexed, and the frontier is kept for Resume. A paused
+// crawl never ends on its own, however long it sits idle.
+func (c *Crawl) Pause() {
+	c.gate.Pause()
+}
+
+// Resume picks a paused crawl back up where it left off.
+func (c *Crawl) Resume() {
+	c.gate.Resume()
+}
+
+// Paused determines if the crawl is paused.
+func (c *Crawl) Paused() bool {
+	return c.gate.Paused()
+}
+
+// Cancel shuts the crawl down early, paused or not. Wait still returns what was
+// crawled so far.
+func (c *Crawl) Cancel() {
+	c.cancel()
+}
+
+// Done is closed once the crawl has finished.
+func (c *Crawl) Done() <-chan struct{} {
+	return c.done
+}
+
+// Wait blocks until the crawl has finished and returns the crawled site.
+func (c *Crawl) Wait() *Website {
+	<-c.done
+	return c.site
+}
+
+// Snapshot gets a copy of what has been crawled so far, which is safe to use
+// while the crawl carries on. It is empty until the crawl has set up its site.
+func (c *Crawl) Snapshot() *Website {
+	c.mu.Lock()
+	site := c.site
+	c.mu.Unlock()
+	if site == nil {
+		return &Website{
+			Pages:       make(map[string]Webpage),
+			AssetChecks: make(map[string]AssetCheck),
+			Stylesheets: make(map[string]Stylesheet),
+			Stats:       c.Stats}
+	}
+	return site.Snapshot()
+}
+
+// openGate is the channel Resumed returns for a gate that isn't paused.
+var openGate = make(chan struct{})
+
+func init() {
+	close(openGate)
+}
+
+// Gate pauses and resumes the RequestWorkers. The zero value is an open gate and
+// a nil *Gate is never paused.
+type Gate struct {
+	mu sync.Mutex
+	// resumed is closed on Resume. It is nil while the gate is open.
+	resumed chan struct{}
+}
+
+// Pause closes the gate.
+func (g *Gate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+// Resume opens the gate, releasing everyone waiting on Resumed.
+func (g *Gate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+// Paused determines if the gate is closed.
+func (g *Gate) Paused() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed != nil
+}
+
+// Resumed gets a channel that is closed once the gate is open.
+func (g *Gate) Resumed() <-chan struct{} {
+	if g == nil {
+		return openGate
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		return openGate
+	}
+	return g.resumed
+}
@@ -8,10 +8,17 @@ and figure out which links the request workers should crawl next.
 package crawler
 
 import (
-	"log"
-	"net/http"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/url"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"crawler/backfill"
@@ -24,21 +31,267 @@ const (
 	RequestBufferSize = 400
 	IndexBufferSize   = 400
 	DebounceTimeout   = 2 * time.Second
+	MaxIdleTimeout    = 200 * time.Millisecond
+	MaxQueryVariants  = 100
+	MaxBodySize       = 10 << 20
+	ResponseTimeout   = 30 * time.Second
+	MaxRetries        = 2
+	RetryBackoff      = 1 * time.Second
+	MaxRedirects      = 10
+	PollInterval      = 5 * time.Millisecond
+	AuthWallThreshold = 0.5
+	AuthWallMinPages  = 20
+
+	FrontierFlushInterval = 30 * time.Second
 )
 
-// Website represents a single website to scrape. All Pages should be on the same
-// domain and multithreaded Page access is encouraged with the included mutex.
+// ErrInactive is the Website.Err of a crawl aborted because no page was
+// indexed for CrawlerConfig.InactivityTimeout.
+var ErrInactive = errors.New("no page indexed within the inactivity timeout, the crawl looks stuck")
+
+// ErrSeedFailed is wrapped by the Website.Err of a crawl that ended early
+// because every seed failed to crawl, so there was nothing to follow.
+var ErrSeedFailed = errors.New("no seed could be crawled")
+
+// ErrAuthWall is the Website.Err of a crawl aborted because most pages
+// redirected to the same place, probably a login page.
+var ErrAuthWall = errors.New("most pages redirect to the same URL, is the crawl logged in?")
+
+// SkipExtensions are the file extensions that aren't fetched as pages by default.
+var SkipExtensions = []string{
+	"pdf", "zip", "gz", "tar", "rar", "7z", "exe", "dmg", "iso",
+	"jpg", "jpeg", "png", "gif", "webp", "svg", "ico", "bmp", "tiff",
+	"mp3", "mp4", "m4a", "mov", "avi", "webm", "wav", "ogg",
+	"css", "js", "woff", "woff2", "ttf", "eot",
+	"doc", "docx", "xls", "xlsx", "ppt", "pptx", "csv"}
+
+// Website represents a single website to scrape. Pages are on the same domain
+// unless CrawlerConfig.AllowHost let in others, and multithreaded Page access is
+// encouraged with the included mutex.
 type Website struct {
 	Domain url.URL
 	Pages  map[string]Webpage
+	Errors []WebpageError
+
+	// Changed lists the keys of the pages a recrawl found new or changed.
+	Changed []string
+
+	// Dropped lists links thrown away because the frontier was full, see
+	// CrawlerConfig.MaxFrontier.
+	Dropped []url.URL
+
+	// Skipped lists the links that were requested but not crawled, like
+	// non-HTML files, with the reason why.
+	Skipped []SkipError
+
+	// RelativeURLs has the writers print paths relative to Domain instead of
+	// absolute URLs.
+	RelativeURLs bool
+
+	AssetChecks map[string]AssetCheck
+
+	// Stylesheets holds what each scanned stylesheet references, see
+	// CrawlerConfig.ScanStylesheets.
+	Stylesheets map[string]Stylesheet
+
+	// MixedContent lists the http: assets found on https pages.
+	MixedContent []MixedContentIssue
+
+	// Feeds lists every feed the pages advertise, once.
+	Feeds []Feed
+
+	// Truncated is set if the crawl stopped early on CrawlerConfig.MaxBytes.
+	Truncated bool
+
+	Stats *CrawlStats
+
+	// Err is set if the crawl was aborted.
+	Err error
+
+	mu sync.RWMutex
+
+	// normalize and hostRewrite are how links were scoped and normalized
+	// during the crawl, for matching them up with pages afterwards.
+	normalize   backfill.NormalizeOptions
+	hostRewrite map[string]string
 }
 
 // Webpage represents specific page on a website that we can identify with its URL.
 // Has Links and static Assets that we care about scraping.
 type Webpage struct {
-	URL    url.URL
+	URL        url.URL
+	StatusCode int
+
+	// FetchDuration is how long it took to request the page and read its
+	// body, for finding slow endpoints.
+	FetchDuration time.Duration
+
 	Links  []url.URL
-	Assets []string
+	Assets []Asset
+
+	// StructuredData holds the JSON-LD blocks found on the page.
+	StructuredData []json.RawMessage
+
+	// Forms holds every form's action and method.
+	Forms []FormInfo
+
+	// ETag and LastModified come from the response headers and let a later
+	// recrawl ask if the page changed. NotModified is set if it hadn't.
+	ETag         string
+	LastModified string
+	NotModified  bool
+
+	// Body is the raw HTML if the crawl was configured with KeepBody.
+	// BodyTruncated is set if the page was longer than MaxBodySize, so only
+	// the start of it was parsed.
+	Body          []byte
+	BodyTruncated bool
+
+	// PaginationGroup is set on pages of a paginated listing, see
+	// CrawlerConfig.PaginationParams.
+	PaginationGroup string
+
+	// ContentHash is the hex SHA-256 of the body if the crawl was configured
+	// with HashContent.
+	ContentHash string
+
+	// NoIndex and NoFollow come from the page's X-Robots-Tag header.
+	NoIndex  bool
+	NoFollow bool
+
+	// DiscoveredVia says whether the page was a seed or found through a link,
+	// an iframe or a GET form, and Depth how many links away from a seed it
+	// was found.
+	DiscoveredVia Discovery
+	Depth         int
+
+	// AuthRequired is set if fetching the page redirected to one of the
+	// configured LoginPaths.
+	AuthRequired bool
+
+	// OtherLinks holds links with schemes that aren't crawled, with
+	// RecordOtherSchemes set.
+	OtherLinks []url.URL
+
+	// ExternalLinks holds the links to other hosts, with RecordExternalLinks
+	// set.
+	ExternalLinks []url.URL
+
+	// MixedContent lists the http: assets of an https page.
+	MixedContent []string
+
+	// Title is the text of the page's <title> and Lang its <html lang>, empty
+	// if it doesn't declare one.
+	Title string
+	Lang  string
+
+	// NextPage and PrevPage are the page's rel=next/prev links, if it's part
+	// of a paginated series.
+	NextPage url.URL
+	PrevPage url.URL
+
+	// EmbeddedLinks are the same-host URLs found in JSON-LD or data-*
+	// attributes, if those extractors are in the config's Visitors.
+	EmbeddedLinks []url.URL
+
+	// RedirectURL is where the page redirected to: the final URL of the
+	// redirects that were followed, or the target of one the RedirectPolicy
+	// didn't follow, in which case the page has the 3xx status and the target
+	// as its only link.
+	RedirectURL url.URL
+
+	// RefreshURL is where the page's <meta http-equiv="refresh"> redirects
+	// to; it is followed like any other link.
+	RefreshURL url.URL
+
+	// Feeds are the feeds the page advertises.
+	Feeds []Feed
+
+	// Frames are the same-host <iframe> and <frame> sources, which are in
+	// Links too.
+	Frames []url.URL
+}
+
+// Discovery is how a page first entered the crawl.
+type Discovery int
+
+const (
+	DiscoveredSeed Discovery = iota + 1
+	DiscoveredLink
+	DiscoveredForm
+	DiscoveredPagination
+	DiscoveredEmbedded
+	DiscoveredIframe
+	DiscoveredSitemap
+)
+
+func (d Discovery) String() string {
+	switch d {
+	case DiscoveredSeed:
+		return "seed"
+	case DiscoveredLink:
+		return "link"
+	case DiscoveredForm:
+		return "form"
+	case DiscoveredPagination:
+		return "pagination"
+	case DiscoveredEmbedded:
+		return "embedded"
+	case DiscoveredIframe:
+		return "iframe"
+	case DiscoveredSitemap:
+		return "sitemap"
+	}
+	return "unknown"
+}
+
+// Asset is a static asset referenced by a page.
+type Asset = backfill.Asset
+
+// FormInfo is a form found on a page.
+type FormInfo = backfill.FormInfo
+
+// Feed is a feed or OpenSearch description advertised by a page.
+type Feed = backfill.Feed
+
+// AssetExtensionFilter picks the assets that are recorded by file extension.
+type AssetExtensionFilter = backfill.AssetExtensionFilter
+
+// Visitor extracts what it cares about from an element of a page.
+type Visitor = backfill.Visitor
+
+// Extractor is an attribute rule for the VisitExtractors visitor.
+type Extractor = backfill.Extractor
+
+// WebpageError records a page that could not be crawled, either because the
+// request failed (StatusCode is 0) or the server answered with an error status.
+// Redirects is the chain of URLs visited if it failed on a RedirectError.
+// Panic is set if crawling it panicked instead, see Fetch.
+type WebpageError struct {
+	URL        url.URL
+	Err        string
+	StatusCode int
+	Retries    int
+	Redirects  []url.URL
+	Panic      bool
+}
+
+func (e WebpageError) Error() string {
+	return fmt.Sprintf("%s: %s", e.URL.String(), e.Err)
+}
+
+// AssetCheck is the result of requesting an asset when ValidateAssets is set.
+// StatusCode is 0 if the request itself failed (see Err) or the asset was
+// never checked, e.g. because robots.txt disallows it.
+type AssetCheck struct {
+	URL        string
+	StatusCode int
+	Err        string
+}
+
+// Broken determines if the asset failed to load.
+func (c AssetCheck) Broken() bool {
+	return c.Err != "" || c.StatusCode >= 400
 }
 
 // WorkerMsg is sent on a channel from crawler goroutines to a monitoring function
@@ -50,101 +303,490 @@ type WorkerMsg struct {
 
 // CrawlerState holds state shared by worker goroutines.
 type CrawlerState struct {
-	WG    *sync.WaitGroup
-	Links chan url.URL
-	Pages chan Webpage
-	Msgs  chan WorkerMsg
-	Done  chan bool
+	WG      *sync.WaitGroup
+	Links   Frontier
+	Assets  Frontier
+	Pages   chan Webpage
+	Errors  chan WebpageError
+	Checks  chan AssetCheck
+	Sheets  chan Stylesheet
+	Msgs    chan WorkerMsg
+	Done    chan bool
+	Config  *CrawlerConfig
+	Stats   *CrawlStats
+	Context context.Context
+
+	// RequestsDone is closed once every RequestWorker has returned.
+	RequestsDone chan bool
+
+	// Unindexed counts pages sent to the IndexWorker that it hasn't finished
+	// indexing, including the one it's working on.
+	Unindexed atomic.Int64
+
+	// Previous is the earlier crawl being refreshed by RecrawlChanged, if any.
+	Previous *Website
+
+	// Site is the website being built, for RequestWorkers streaming links.
+	Site *Website
+
+	// Gate holds the RequestWorkers back while the crawl is paused. It is nil
+	// unless the crawl was started with StartCrawler.
+	Gate *Gate
+
+	// Scope decides which links are queued: the config's Scope, or the
+	// DefaultScope for the site.
+	Scope ScopePolicy
+
+	// visited records every page key ever queued and how it was found, so a
+	// page is only crawled once, and depths how many links from a seed it was
+	// queued at. Like variants, they are guarded by the site's lock.
+	visited   map[string]Discovery
+	depths    map[string]int
+	assets    map[string]bool
+	hosts     map[string]bool
+	feeds     map[string]bool
+	variants  map[string]int
+	terminate sync.Once
+
+	// pending holds the links queued but not yet finished with, by page key,
+	// for saving to FrontierPath. It is nil unless FrontierPath is set, and
+	// like visited it is guarded by the site's lock.
+	pending map[string]url.URL
+
+	// truncated is set when the monitor stops the crawl on MaxBytes.
+	truncated atomic.Bool
+
+	// lastIndexed is when the IndexWorker last got a page, in Unix
+	// nanoseconds, and inactive is set when the monitor stops the crawl on
+	// InactivityTimeout.
+	lastIndexed atomic.Int64
+	inactive    atomic.Bool
+
+	// abort cancels Context, giving up on the requests in flight.
+	abort context.CancelFunc
+
+	// redirected counts the pages that ended up at each redirect target, out
+	// of all the pages the IndexWorker has seen, for spotting an auth wall.
+	// The IndexWorker is the only one to use them.
+	redirected map[string]int
+	seen       int
+	walled     bool
+
+	// seeds holds the keys of the seeds queued, true once one has come back
+	// as an error, to end a crawl that has nowhere to go at once instead of
+	// after the debounce. A seed that only redirects hands over to its
+	// target, and seedCrawled is set once any seed is indexed. After the
+	// seeds are queued the IndexWorker is the only one to use them.
+	seeds       map[string]bool
+	seedCrawled bool
 }
 
-// Crawler sets up channels and crawling goroutines. Blocks on a shared WaitGroup
-// for everything to finish before cleaning up and returning the crawled site.
+// Crawler crawls a site using the DefaultConfig.
 func Crawler(link url.URL) *Website {
+	return CrawlerWithConfig(link, DefaultConfig())
+}
+
+// CrawlerWithConfig crawls a site using the passed configuration.
+func CrawlerWithConfig(link url.URL, config CrawlerConfig) *Website {
+	return crawl(context.Background(), []url.URL{link}, config, nil, nil)
+}
+
+// CrawlerWithContext is CrawlerWithConfig with a context. Cancelling it or
+// hitting its deadline stops the crawl early, returning what was crawled so
+// far, and the config's hooks are passed it.
+func CrawlerWithContext(ctx context.Context, link url.URL, config CrawlerConfig) *Website {
+	return crawl(ctx, []url.URL{link}, config, nil, nil)
+}
+
+// CrawlURL crawls a site using the DefaultConfig, starting from a URL typed by a
+// person. See ParseSeed for what it accepts.
+func CrawlURL(raw string) (*Website, error) {
+	link, err := ParseSeed(raw)
+	if err != nil {
+		return nil, err
+	}
+	return Crawler(link), nil
+}
+
+// ParseSeed turns a URL typed by a person into a crawlable seed. Bare hosts like
+// example.com or example.com/path get an http:// scheme, while URLs that already
+// have a scheme must use http or https and name a host.
+func ParseSeed(raw string) (url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return url.URL{}, errors.New("empty URL")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	link, err := url.Parse(raw)
+	if err != nil {
+		return url.URL{}, fmt.Errorf("malformed URL %q: %w", raw, err)
+	}
+	if link.Scheme != "http" && link.Scheme != "https" {
+		return url.URL{}, fmt.Errorf("unsupported scheme %q in %q, use http or https", link.Scheme, raw)
+	}
+	if link.Hostname() == "" {
+		return url.URL{}, fmt.Errorf("no host in %q", raw)
+	}
+	return *link, nil
+}
+
+// CrawlerSeeds crawls a site starting from several entry points at once, e.g. the
+// URLs listed in an old sitemap. The crawl is scoped to the first seed's host and
+// seeds on any other host are skipped.
+func CrawlerSeeds(seeds []url.URL) *Website {
+	return CrawlerSeedsWithConfig(seeds, DefaultConfig())
+}
+
+// CrawlerSeedsWithConfig is CrawlerSeeds using the passed configuration.
+func CrawlerSeedsWithConfig(seeds []url.URL, config CrawlerConfig) *Website {
+	return crawl(context.Background(), seeds, config, nil, nil)
+}
+
+// RecrawlChanged crawls a previously crawled site again, sending conditional
+// requests built from the stored ETag and Last-Modified headers. Pages the server
+// reports as unchanged (304) reuse their old data, including their links, so new
+// pages are still discovered through the ones that did change. The keys of new
+// and changed pages are listed in Changed on the returned site.
+func RecrawlChanged(old *Website) *Website {
+	return crawl(context.Background(), []url.URL{old.Domain}, DefaultConfig(), old, nil)
+}
+
+// crawl sets up channels and crawling goroutines, then runs the IndexWorker until every
+// RequestWorker is done and everything has been indexed before cleaning up and returning
+// the crawled site. Cancelling ctx shuts the crawl down early. A handle from
+// StartCrawler is given the site as soon as it exists, and its gate pauses the crawl.
+func crawl(ctx context.Context, seeds []url.URL, config CrawlerConfig, previous *Website, handle *Crawl) *Website {
+	if len(seeds) == 0 {
+		return &Website{
+			Pages:       make(map[string]Webpage),
+			AssetChecks: make(map[string]AssetCheck),
+			Stylesheets: make(map[string]Stylesheet)}
+	}
+	if config.Policy == nil {
+		config.Policy = CompositePolicy{}
+	}
+	// Build the shared client now rather than have the workers race to.
+	config.HTTPClient()
+	if policy, ok := config.Policy.(ConfigurablePolicy); ok {
+		policy.Configure(&config)
+	}
+	if config.Stats == nil {
+		config.Stats = &CrawlStats{}
+	}
+	if config.DebounceTimeout == 0 {
+		config.DebounceTimeout = DebounceTimeout
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = PollInterval
+	}
+	if config.IndexBuffer == 0 {
+		config.IndexBuffer = IndexBufferSize
+	}
+	if config.FrontierFlush == 0 {
+		config.FrontierFlush = FrontierFlushInterval
+	}
+	if config.Workers <= 0 {
+		config.Workers = NumWorkers
+	}
+
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	link := seeds[0]
+	backfill.NormalizeURL(&link, config.NormalizeOptions())
 	site := Website{
-		Domain: link,
-		Pages:  make(map[string]Webpage)}
+		Domain:      link,
+		Stats:       config.Stats,
+		Pages:       make(map[string]Webpage),
+		AssetChecks: make(map[string]AssetCheck),
+		Stylesheets: make(map[string]Stylesheet),
+		normalize:   config.NormalizeOptions(),
+		hostRewrite: config.HostRewrite}
 
 	state := CrawlerState{
-		WG:    &sync.WaitGroup{},
-		Links: make(chan url.URL, RequestBufferSize),
-		Pages: make(chan Webpage, IndexBufferSize),
-		Msgs:  make(chan WorkerMsg, MsgsBufferSize),
-		Done:  make(chan bool, TotalWorkers)}
-	state.Links <- link
-
-	// Spawn worker pool w/ IDs [0,NumWorkers)
-	for i := 0; i < NumWorkers; i += 1 {
+		WG:           &sync.WaitGroup{},
+		Links:        NewFrontier(config.Strategy),
+		Assets:       NewFrontier(BreadthFirst),
+		Pages:        make(chan Webpage, config.IndexBuffer),
+		Errors:       make(chan WebpageError, config.IndexBuffer),
+		Checks:       make(chan AssetCheck, config.IndexBuffer),
+		Sheets:       make(chan Stylesheet, config.IndexBuffer),
+		Msgs:         make(chan WorkerMsg, (config.Workers+1)*8),
+		Done:         make(chan bool),
+		RequestsDone: make(chan bool),
+		Config:       &config,
+		Stats:        config.Stats,
+		Context:      ctx,
+		abort:        abort,
+		Previous:     previous,
+		Site:         &site,
+		visited:      make(map[string]Discovery),
+		depths:       make(map[string]int),
+		assets:       make(map[string]bool),
+		seeds:        make(map[string]bool)}
+	if handle != nil {
+		state.Gate = handle.gate
+		handle.setSite(&site)
+	}
+	state.Scope = config.Scope
+	if state.Scope == nil {
+		state.Scope = state.DefaultScope(site.Domain)
+	}
+	if config.FrontierPath != "" {
+		state.pending = make(map[string]url.URL)
+	}
+	if config.Login != nil {
+		if err := config.Login(ctx, config.HTTPClient()); err != nil {
+			site.mu.Lock()
+			site.Err = fmt.Errorf("login failed: %w", err)
+			site.mu.Unlock()
+			return &site
+		}
+	}
+
+	resumed := false
+	if config.FrontierPath != "" {
+		var err error
+		if resumed, err = state.Resume(); err != nil {
+			site.mu.Lock()
+			site.Err = fmt.Errorf("can't resume the crawl: %w", err)
+			site.mu.Unlock()
+			return &site
+		}
+	}
+	if resumed {
+		// The seeds were queued by the crawl being resumed.
+		seeds = nil
+	}
+
+	seedVia := config.SeedDiscovery
+	if seedVia == 0 {
+		seedVia = DiscoveredSeed
+	}
+	for _, seed := range seeds {
+		if !backfill.SameHost(&seed, &site.Domain) && !state.Scope.InScope(seed) {
+			config.Logf(LogInfo, "skipping seed on another host: %s\n", seed.String())
+			continue
+		}
+		backfill.NormalizeURL(&seed, config.NormalizeOptions())
+		key := site.PageKey(&seed)
+		if _, ok := state.visited[key]; ok {
+			continue
+		}
+		state.visited[key] = seedVia
+		if state.pending != nil {
+			state.pending[key] = seed
+		}
+		state.Links.Push(seed)
+		state.seeds[key] = false
+	}
+
+	state.lastIndexed.Store(time.Now().UnixNano())
+
+	// Spawn worker pool w/ IDs [0,Workers)
+	state.Stats.Workers.Store(int64(config.Workers))
+	for i := 0; i < config.Workers; i += 1 {
 		state.WG.Add(1)
 		go RequestWorker(i, &state)
 	}
-	state.WG.Add(1)
-	go IndexWorker(NumWorkers, &state, &site)
-
-	go MonitorCrawler(&state)
-	state.WG.Wait()
+	go MonitorCrawler(&state, nil)
+	if config.FrontierPath != "" {
+		go FlushFrontier(&state)
+	}
+	go func() {
+		state.WG.Wait()
+		close(state.RequestsDone)
+	}()
+	IndexWorker(config.Workers, &state, &site)
+	site.mu.Lock()
+	site.Truncated = state.truncated.Load()
+	if state.inactive.Load() && site.Err == nil {
+		site.Err = ErrInactive
+	}
+	finished := len(state.pending) == 0
+	site.mu.Unlock()
+	if config.FrontierPath != "" {
+		// Keep the progress of a crawl that was cut short for the next run.
+		if !finished {
+			state.SaveFrontier()
+		} else if err := os.Remove(config.FrontierPath); err != nil && !os.IsNotExist(err) {
+			config.Logf(LogError, "can't remove the frontier file: %s\n", err)
+		}
+	}
+	config.Logf(LogInfo, "crawled %d pages of %s, %d failed\n",
+		state.Stats.Indexed.Load(), site.Domain.Host, state.Stats.Failures.Load())
 
 	defer close(state.Pages)
-	defer close(state.Links)
+	defer close(state.Errors)
+	defer close(state.Checks)
+	defer close(state.Sheets)
 	defer close(state.Msgs)
 	return &site
 }
 
 // MonitorCrawler listens for messages from other workers about their current status (busy/free).
-// If all the workers are without work for a specific time interval, puts messages
-// on a channel to instruct them to terminate. Debouncing the status messages from
+// If all the workers are without work for a specific time interval, closes the
+// done channel through Terminate to instruct them to stop. Debouncing the status messages from
 // workers is important because there are conditions, specifically after crawling and
 // indexing the root of the "site tree", where all workers are free for a moment.
+// Closing stop makes the monitor return without terminating the workers, while
+// cancelling the state's Context terminates them. It also stops the crawl early
+// on MaxBytes and InactivityTimeout.
 // There should only be ONE MonitorCrawler goroutine.
-func MonitorCrawler(state *CrawlerState) {
+func MonitorCrawler(state *CrawlerState, stop <-chan bool) {
 	workers := make(map[int]bool)
-	all_free := false
-	var timestamp time.Time
+	var free_since, drained_since time.Time
+	// One ticker for the whole crawl, rather than a timer per message, also
+	// keeps a stream of status messages from putting the checks off.
+	ticker := time.NewTicker(state.Config.PollInterval)
+	defer ticker.Stop()
 
 Loop:
 	for {
 		select {
 		case msg := <-state.Msgs:
 			workers[msg.ID] = msg.Busy
-		default:
-			if len(workers) == TotalWorkers && backfill.DeepCompare(workers, false) {
-				// Debounce the "free" messages before terminating workers.
-				if all_free && time.Since(timestamp) >= DebounceTimeout {
-					// Terminate the workers.
-					for i := 0; i < len(workers); i++ {
-						state.Done <- true
-					}
-
-					close(state.Done)
-					break Loop
-				} else if !all_free {
-					// Workers are free for at least this moment, start timer.
-					all_free = true
-					timestamp = time.Now()
+		case <-state.Done:
+			// Something else shut the crawl down.
+			break Loop
+		case <-stop:
+			break Loop
+		case <-state.Context.Done():
+			// The caller gave up on the crawl.
+			state.Terminate()
+			break Loop
+		case <-ticker.C:
+			if max := state.Config.MaxBytes; max > 0 && state.Stats.Bytes.Load() >= max {
+				state.Config.Logf(LogInfo, "downloaded %d bytes, stopping the crawl\n", state.Stats.Bytes.Load())
+				state.truncated.Store(true)
+				state.Terminate()
+				break Loop
+			}
+			if state.Gate.Paused() {
+				// Being paused isn't being stuck.
+				state.lastIndexed.Store(time.Now().UnixNano())
+			} else if timeout := state.Config.InactivityTimeout; timeout > 0 &&
+				time.Since(time.Unix(0, state.lastIndexed.Load())) >= timeout {
+				state.Config.Logf(LogError, "nothing indexed for %s, stopping the crawl\n", timeout)
+				state.inactive.Store(true)
+				state.Terminate()
+				// The requests in flight are probably stuck too.
+				if state.abort != nil {
+					state.abort()
 				}
-			} else {
-				// A worker became busy, reset.
-				all_free = false
+				break Loop
+			}
+			if len(workers) != state.Config.WorkerCount() || !backfill.DeepCompare(workers, false) || state.Gate.Paused() {
+				// A worker became busy or the crawl is paused, reset.
+				free_since = time.Time{}
+				drained_since = time.Time{}
+				continue
+			}
+
+			// Workers are free for at least this moment, start the timers.
+			now := time.Now()
+			if free_since.IsZero() {
+				free_since = now
+			}
+			queued := state.Queued()
+			if queued > 0 {
+				drained_since = time.Time{}
+			} else if drained_since.IsZero() {
+				drained_since = now
+			}
+
+			var drained time.Duration
+			if !drained_since.IsZero() {
+				drained = now.Sub(drained_since)
+			}
+			if ShouldTerminate(workers, queued, now.Sub(free_since), drained, state.Config) {
+				state.Terminate()
+				break Loop
 			}
 		}
 	}
 }
 
-// RequestWorker awaits URLS of pages to crawl on the links channel. Should be run as a
+// ShouldTerminate decides if the crawl is over given the latest status of each
+// worker, how much work is queued, how long every worker has been free and how
+// long nothing has been queued. All the workers have to have reported in and be
+// free. Then the "free" state is debounced: it has to hold for DebounceTimeout,
+// since all the workers are briefly free right after the root is indexed and
+// before a RequestWorker picks up its links. If nothing has been queued for
+// MaxIdle either, there is no work that could be in flight, so that's enough.
+func ShouldTerminate(workers map[int]bool, queued int, free, drained time.Duration, config *CrawlerConfig) bool {
+	if len(workers) != config.WorkerCount() || !backfill.DeepCompare(workers, false) {
+		return false
+	}
+	if free >= config.DebounceTimeout {
+		return true
+	}
+	return config.MaxIdle > 0 && queued == 0 && drained >= config.MaxIdle
+}
+
+// Terminate tells every worker to stop by closing the done channel. It is the only
+// place Done gets closed, so it is safe to call from any number of shutdown paths.
+func (state *CrawlerState) Terminate() {
+	state.terminate.Do(func() {
+		close(state.Done)
+	})
+}
+
+// Queued counts the links and assets waiting to be fetched, plus the pages and results
+// the IndexWorker hasn't finished with.
+func (state *CrawlerState) Queued() int {
+	return state.Links.Len() + state.Assets.Len() + int(state.Unindexed.Load()) + len(state.Errors) + len(state.Checks) + len(state.Sheets)
+}
+
+// RequestWorker awaits URLS of pages to crawl on the links frontier. Should be run as a
 // goroutine, and multiple workers can run concurrently. After fetching a page,
 // it parses out links and static assets on the page and sends them on a channel
-// the IndexWorker. If there are no links available immediately on the channel,
+// the IndexWorker. If there are no links available immediately on the frontier,
 // sends a message to the monitor that it has no work to do. The worker will
 // continue doing this until it either finds more work to do or it receives a
 // message from the monitor to terminate, in which case it will stop looping
-// and decrement its WaitGroup counter.
+// and decrement its WaitGroup counter. Every request the crawl makes goes through
+// a RequestWorker and the Policy, so the pool size bounds the crawl's concurrency.
+// A panic while fetching a link fails that link only, see Fetch.
 func RequestWorker(id int, state *CrawlerState) {
 	msg := WorkerMsg{id, true}
 	first := true
 
 Loop:
 	for {
+		// Don't start on more work once the crawl has been shut down.
 		select {
-		case link := <-state.Links:
+		case <-state.Done:
+			break Loop
+		default:
+		}
+
+		// While paused, sit idle without touching the frontier.
+		if state.Gate.Paused() {
+			if msg.Busy {
+				msg.Busy = false
+				state.Msgs <- msg
+			}
+			select {
+			case <-state.Done:
+				break Loop
+			case <-state.Gate.Resumed():
+			}
+			continue
+		}
+
+		// Pages come first so asset checks never hold up the crawl itself.
+		link, ok := state.Links.Pop()
+		isAsset := false
+		if !ok {
+			link, ok = state.Assets.Pop()
+			isAsset = ok
+		}
+		state.Stats.Queued.Store(int64(state.Links.Len()))
+		switch {
+		case ok:
 			// Tell the monitor we have work to do if our last msg was different.
 			if !msg.Busy || first {
 				msg.Busy = true
@@ -152,82 +794,553 @@ Loop:
 				state.Msgs <- msg
 			}
 
-			response, err := http.Get(link.String())
-			if err != nil {
-				log.Printf("[%d] request failed for URL: %s\n", id, link.String())
-				continue
-			}
-			links, assets := backfill.ParseAssets(response)
-			page := Webpage{link, links, assets}
-
-			log.Printf("[%d] requested %s\n", id, link.String())
-			state.Pages <- page
+			state.Fetch(id, link, isAsset)
 		default:
+			if msg.Busy {
+				msg.Busy = false
+				state.Msgs <- msg
+			}
+			// Nothing to do; wait a moment for the IndexWorker to find some.
 			select {
 			case <-state.Done:
 				break Loop
-			default:
-				if msg.Busy {
-					msg.Busy = false
-					state.Msgs <- msg
-				}
+			case <-time.After(state.Config.PollInterval):
 			}
 		}
 	}
+	state.Config.Logf(LogDebug, "[%d] stopped\n", id)
 	state.WG.Done()
 }
 
+// Fetch requests a link popped off a frontier and sends the result on to the
+// IndexWorker: a page or an error for links, a check and maybe a stylesheet
+// for assets. If anything panics along the way, the panic is logged with its
+// stack and the link recorded as failed, so one bad page can't bring down the
+// crawl or the program embedding it.
+func (state *CrawlerState) Fetch(id int, link url.URL, isAsset bool) {
+	release := func() {}
+	defer func() {
+		if r := recover(); r != nil {
+			release()
+			state.Recovered(id, link, isAsset, r)
+		}
+	}()
+
+	// Policies see the host that's actually requested, so rate limits and
+	// robots.txt apply to the staging server rather than production.
+	fetch := backfill.RewriteHost(&link, state.Config.HostRewrite)
+	acquired, err := state.Config.Policy.Acquire(state.Context, &fetch)
+	if err != nil {
+		state.Config.Logf(LogDebug, "[%d] not fetching %s: %s\n", id, link.String(), err)
+		// A link given up on because the crawl was cancelled is
+		// still pending.
+		if !isAsset && state.Context.Err() == nil {
+			state.Site.mu.Lock()
+			state.settle(&link)
+			state.Site.mu.Unlock()
+		}
+		return
+	}
+	release = sync.OnceFunc(acquired)
+	if state.Config.Recorder != nil {
+		state.Config.Recorder.Record(&link)
+	}
+	if isAsset {
+		if IsStylesheet(&link, &state.Site.Domain, state.Config) {
+			var sheet Stylesheet
+			var check AssetCheck
+			state.request(release, func() {
+				sheet, check = ScanStylesheet(state.Context, &link, &state.Site.Domain, state.Config)
+			})
+			state.Config.Logf(LogDebug, "[%d] scanned %s\n", id, link.String())
+			if state.Config.ValidateAssets {
+				state.Checks <- check
+			}
+			state.Sheets <- sheet
+			return
+		}
+		var check AssetCheck
+		state.request(release, func() {
+			check = CheckAsset(state.Context, &link, state.Config)
+		})
+		state.Config.Logf(LogDebug, "[%d] checked %s\n", id, link.String())
+		state.Checks <- check
+		return
+	}
+
+	var previous *Webpage
+	if state.Previous != nil {
+		if old, ok := state.Previous.Pages[state.Previous.PageKey(&link)]; ok {
+			previous = &old
+		}
+	}
+
+	// Streamed links that find the frontier full under FrontierBlock can't
+	// wait here, since it's RequestWorkers that make room. They go on the
+	// page for the IndexWorker instead.
+	var onLink func(url.URL, Discovery)
+	var deferred []url.URL
+	if state.Config.StreamLinks && !state.Config.SeedsOnly && state.Config.FollowIf == nil {
+		onLink = func(found url.URL, how Discovery) {
+			state.Site.mu.Lock()
+			defer state.Site.mu.Unlock()
+			if max := state.Config.MaxFrontier; max > 0 && state.Config.FrontierPolicy == FrontierBlock &&
+				state.Links.Len() >= max {
+				deferred = append(deferred, found)
+				return
+			}
+			depth := state.depths[state.Site.PageKey(&link)] + 1
+			state.QueueLink(id, state.Site, found, how, depth)
+		}
+	}
+
+	var page Webpage
+	state.request(release, func() {
+		page, err = CrawlPageStream(state.Context, link, state.Config, previous, onLink)
+	})
+	if err == nil && len(deferred) > 0 {
+		page.Links = append(page.Links, deferred...)
+	}
+
+	switch err := err.(type) {
+	case nil:
+		if page.NotModified {
+			state.Config.Logf(LogDebug, "[%d] unchanged %s\n", id, link.String())
+		} else {
+			state.Config.Logf(LogDebug, "[%d] requested %s\n", id, link.String())
+		}
+		state.Unindexed.Add(1)
+		state.Pages <- page
+	case *SkipError:
+		state.Config.Logf(LogDebug, "[%d] %s\n", id, err)
+		state.Site.mu.Lock()
+		state.Site.Skipped = append(state.Site.Skipped, *err)
+		state.settle(&link)
+		state.Site.mu.Unlock()
+	case *StopError:
+		state.Config.Logf(LogError, "[%d] %s returned %s, stopping the crawl\n", id, link.String(), err.Err)
+		state.Stats.Failures.Add(1)
+		state.Errors <- err.WebpageError
+		state.Terminate()
+	case WebpageError:
+		if err.StatusCode == 0 {
+			state.Config.Logf(LogError, "[%d] request failed for URL: %s\n", id, link.String())
+		} else {
+			state.Config.Logf(LogError, "[%d] %s returned %s\n", id, link.String(), err.Err)
+		}
+		state.Stats.Failures.Add(1)
+		state.Errors <- err
+	}
+}
+
+// request runs a request counted in the stats as in flight, releasing its
+// Policy slot once it's done, even if it panics.
+func (state *CrawlerState) request(release func(), do func()) {
+	state.Stats.StartRequest()
+	defer state.Stats.FinishRequest()
+	defer release()
+	do()
+}
+
+// Recovered records a link whose Fetch panicked as failed: an error for pages
+// and, with ValidateAssets set, a check for assets.
+func (state *CrawlerState) Recovered(id int, link url.URL, isAsset bool, r any) {
+	state.Config.Logf(LogError, "[%d] panic fetching %s: %v\n%s", id, link.String(), r, debug.Stack())
+	msg := fmt.Sprintf("panic: %v", r)
+	if isAsset {
+		if state.Config.ValidateAssets {
+			state.Checks <- AssetCheck{URL: link.String(), Err: msg}
+		}
+		return
+	}
+	state.Stats.Failures.Add(1)
+	state.Errors <- WebpageError{URL: link, Err: msg, Panic: true}
+}
+
+// QueueLink pushes a link found on a page onto the links frontier unless it is
+// out of the crawl's Scope, was already queued, or is one query variant too many
+// of its path. Pagination links jump the queue if the frontier allows it.
+// The page will be recorded as discovered via how, depth links away from a seed;
+// links deeper than MaxDepth are left out. The caller must hold the site's lock.
+func (state *CrawlerState) QueueLink(id int, site *Website, link url.URL, how Discovery, depth int) {
+	// Don't download PDFs, images and other binaries only to find they aren't pages.
+	if backfill.HasExtension(&link, state.Config.SkipExtensions) {
+		return
+	}
+	// Throw out links the crawl wasn't asked to follow, like other hosts.
+	backfill.NormalizeURL(&link, state.Config.NormalizeOptions())
+	if !state.Scope.InScope(link) {
+		return
+	}
+	key := site.PageKey(&link)
+	if _, ok := state.visited[key]; ok {
+		return
+	}
+	// Not marked visited, in case a shorter path to it turns up.
+	if state.Config.MaxDepth > 0 && depth > state.Config.MaxDepth {
+		return
+	}
+	// Stop following a path once it has too many query variants;
+	// it's probably a trap like an endless calendar or pager.
+	if link.RawQuery != "" && state.Config.MaxQueryVariants > 0 {
+		if state.variants == nil {
+			state.variants = make(map[string]int)
+		}
+		state.variants[link.Path] += 1
+		if state.variants[link.Path] == state.Config.MaxQueryVariants+1 {
+			state.Config.Logf(LogInfo, "[%d] warning: more than %d query variants of %s, ignoring the rest\n",
+				id, state.Config.MaxQueryVariants, link.Path)
+		}
+		if state.variants[link.Path] > state.Config.MaxQueryVariants {
+			return
+		}
+	}
+
+	// We have not already crawled this URL; mark it visited
+	// so mulitple workers do not end up requesting the same link.
+	if state.visited == nil {
+		state.visited = make(map[string]Discovery)
+	}
+	state.visited[key] = how
+	if state.depths == nil {
+		state.depths = make(map[string]int)
+	}
+	state.depths[key] = depth
+	if state.Config.FrontierPolicy == FrontierDrop && state.Config.MaxFrontier > 0 &&
+		state.Links.Len() >= state.Config.MaxFrontier {
+		// Still marked visited, so each link is only dropped once.
+		site.Dropped = append(site.Dropped, link)
+		return
+	}
+	if state.pending != nil {
+		state.pending[key] = link
+	}
+	// Pagination is crawled eagerly so a series isn't left half done.
+	if front, ok := state.Links.(PriorityFrontier); ok && how == DiscoveredPagination {
+		front.PushFront(link)
+		return
+	}
+	state.Links.Push(link)
+}
+
+// QueueAsset pushes an asset onto the assets frontier, once, if it's to be
+// checked (ValidateAssets) or scanned as a stylesheet (ScanStylesheets). The
+// caller must hold the site's lock.
+func (state *CrawlerState) QueueAsset(site *Website, asset string) {
+	if state.assets[asset] {
+		return
+	}
+	link, err := url.Parse(asset)
+	if err != nil {
+		return
+	}
+	if !state.Config.ValidateAssets && !IsStylesheet(link, &site.Domain, state.Config) {
+		return
+	}
+	if state.assets == nil {
+		state.assets = make(map[string]bool)
+	}
+	// Stylesheets that @import each other are only scanned once.
+	state.assets[asset] = true
+	state.Assets.Push(*link)
+}
+
+// AllowedHost determines if links to another host are crawled by asking the
+// config's AllowHost, once per host. Without AllowHost no other host is. The
+// caller must hold the site's lock.
+func (state *CrawlerState) AllowedHost(host string) bool {
+	if state.Config.AllowHost == nil {
+		return false
+	}
+	allowed, ok := state.hosts[host]
+	if !ok {
+		allowed = state.Config.AllowHost(state.Context, host)
+		if state.hosts == nil {
+			state.hosts = make(map[string]bool)
+		}
+		state.hosts[host] = allowed
+		if !allowed {
+			state.Config.Logf(LogDebug, "excluding host %s\n", host)
+		}
+	}
+	return allowed
+}
+
+// AuthWall counts where each page was redirected to and determines if more than
+// the AuthWallThreshold of them ended up at the same URL, which is what a crawl
+// stuck behind a login page looks like. It warns the first time it does.
+func (state *CrawlerState) AuthWall(id int, page Webpage) bool {
+	threshold := state.Config.AuthWallThreshold
+	if threshold <= 0 {
+		return false
+	}
+	state.seen += 1
+	if page.RedirectURL.String() == "" {
+		return false
+	}
+	if state.redirected == nil {
+		state.redirected = make(map[string]int)
+	}
+	target := page.RedirectURL.String()
+	state.redirected[target] += 1
+	count := state.redirected[target]
+	if state.seen < AuthWallMinPages || float64(count) <= threshold*float64(state.seen) {
+		return false
+	}
+	if !state.walled {
+		state.walled = true
+		state.Config.Logf(LogError, "[%d] warning: %d of %d pages redirected to %s, is the crawl logged in?\n",
+			id, count, state.seen, target)
+	}
+	return true
+}
+
+// SeedFailed ends the crawl with ErrSeedFailed once every seed has failed and
+// none was indexed, since no links can turn up to keep it going. A panic is a
+// bug in the crawler rather than a seed that can't be crawled, so it doesn't
+// count; the crawl waits out the debounce instead.
+func (state *CrawlerState) SeedFailed(id int, site *Website, pageErr WebpageError) {
+	if pageErr.Panic {
+		return
+	}
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	key := site.PageKey(&pageErr.URL)
+	if _, ok := state.seeds[key]; !ok || state.seedCrawled || site.Err != nil {
+		return
+	}
+	state.seeds[key] = true
+	for _, failed := range state.seeds {
+		if !failed {
+			return
+		}
+	}
+	state.Config.Logf(LogError, "[%d] every seed failed, stopping the crawl\n", id)
+	site.Err = fmt.Errorf("%w: %s", ErrSeedFailed, pageErr.Error())
+	state.Terminate()
+}
+
+// SeedIndexed notes that a seed was crawled. A seed that only redirected
+// (see Webpage.RedirectURL) hands over to its target instead, if that was
+// queued, so SeedFailed still counts it when the target fails. The caller
+// must hold the site's lock.
+func (state *CrawlerState) SeedIndexed(site *Website, key string, page Webpage) {
+	if _, ok := state.seeds[key]; !ok {
+		return
+	}
+	delete(state.seeds, key)
+	if page.StatusCode >= 300 && page.StatusCode < 400 && page.RedirectURL.String() != "" {
+		target := page.RedirectURL
+		backfill.NormalizeURL(&target, state.Config.NormalizeOptions())
+		next := site.PageKey(&target)
+		if _, ok := state.visited[next]; ok && next != key {
+			if _, ok := state.seeds[next]; !ok {
+				state.seeds[next] = false
+			}
+			return
+		}
+	}
+	state.seedCrawled = true
+}
+
+// awaitFrontier blocks while the links frontier is full under FrontierBlock.
+// Errors, asset checks and stylesheets are still recorded while it waits, since
+// RequestWorkers stuck sending them can't make room. It gives up if they're
+// stuck waiting on a full pages channel, since only indexing can free them, or
+// once the crawl is shutting down.
+func (state *CrawlerState) awaitFrontier(id int, site *Website) {
+	max := state.Config.MaxFrontier
+	if max <= 0 || state.Config.FrontierPolicy != FrontierBlock || state.Links.Len() < max {
+		return
+	}
+	ticker := time.NewTicker(state.Config.PollInterval)
+	defer ticker.Stop()
+	for state.Links.Len() >= max && len(state.Pages) < cap(state.Pages) {
+		select {
+		case <-state.Done:
+			return
+		case check := <-state.Checks:
+			state.RecordCheck(site, check)
+		case sheet := <-state.Sheets:
+			state.RecordSheet(site, sheet)
+		case pageErr := <-state.Errors:
+			state.RecordError(id, site, pageErr)
+		case <-ticker.C:
+		}
+	}
+}
+
+// RecordCheck adds an asset check to the site.
+func (state *CrawlerState) RecordCheck(site *Website, check AssetCheck) {
+	site.mu.Lock()
+	site.AssetChecks[check.URL] = check
+	site.mu.Unlock()
+}
+
+// RecordSheet adds a scanned stylesheet to the site and queues what it refers to.
+func (state *CrawlerState) RecordSheet(site *Website, sheet Stylesheet) {
+	site.mu.Lock()
+	site.Stylesheets[sheet.URL] = sheet
+	for _, imported := range sheet.Imports {
+		state.QueueAsset(site, imported)
+	}
+	for _, asset := range sheet.Assets {
+		state.QueueAsset(site, asset.URL)
+	}
+	site.mu.Unlock()
+}
+
+// RecordError adds a page that failed to crawl to the site's Errors.
+func (state *CrawlerState) RecordError(id int, site *Website, pageErr WebpageError) {
+	site.mu.Lock()
+	site.Errors = append(site.Errors, pageErr)
+	// Requests that failed because the crawl was cancelled are still pending.
+	if state.Context.Err() == nil {
+		state.settle(&pageErr.URL)
+	}
+	site.mu.Unlock()
+	if pageErr.StatusCode >= 400 {
+		state.Stats.BrokenLinks.Add(1)
+	}
+	state.SeedFailed(id, site, pageErr)
+}
+
 // IndexWorker awaits parsed webpages on the pages channel, adds them to the sitemap, and
-// sends any uncrawled links from the page back to the RequestWorker via the links channel. 
-// It uses the same technique as the RequestWorker to notify the MonitorWorker of its status 
-// and to know when to terminate.
-// There should only be ONE IndexWorker goroutine in this lock-free implementation.
-// TODO: Make this independent of MonitorCrawler and remove busy/free message sending
-// 	     because this runs in only one goroutine and doesn't need locks.
+// sends any uncrawled links from the page back to the RequestWorker via the links frontier.
+// Pages that failed to crawl arrive on the errors channel and are collected on the site.
+// With ValidateAssets set, each new asset is queued for the RequestWorkers to check and
+// the results come back on the checks channel.
+// It doesn't take part in the monitor's busy/free protocol. Instead it waits for the
+// requests done channel to close, which happens once every RequestWorker has returned,
+// and stops after indexing everything they sent so no page in flight is dropped.
+// There is only ONE IndexWorker. It holds site.mu while it updates the sitemap and
+// the visited, depths and pending maps, which RequestWorkers also lock when they
+// record a skipped link, settle one they gave up on or, with StreamLinks, queue
+// links as they parse.
+// FollowIf and OnPage are called without the lock, so they may read the site.
 func IndexWorker(id int, state *CrawlerState, site *Website) {
-	msg := WorkerMsg{id, true}
-	first := true
-Loop:
 	for {
 		select {
 		case page := <-state.Pages:
-			// Tell the Monitor that we have work to do.
-			if !msg.Busy || first {
-				msg.Busy = true
-				first = false
-				state.Msgs <- msg
+			state.lastIndexed.Store(time.Now().UnixNano())
+			state.awaitFrontier(id, site)
+			if state.AuthWall(id, page) && state.Config.AbortOnAuthWall {
+				site.mu.Lock()
+				site.Err = ErrAuthWall
+				site.mu.Unlock()
+				state.Terminate()
 			}
+			// Ask before locking, so FollowIf can look at the site.
+			follow := state.Config.FollowIf == nil || state.Config.FollowIf(state.Context, page)
+
 			// Add page to the sitemap
-			site.Pages[page.URL.Path] = page
-			log.Printf("[%d] indexed %s\n", id, page.URL.String())
-
-			// Check the links on the page to find out what to crawl next.
-			for _, link := range page.Links {
-				// Throw out links from different hosts.
-				if !backfill.SameHost(&link, &site.Domain) {
-					continue
+			site.mu.Lock()
+			key := site.PageKey(&page.URL)
+			// Links to other hosts came along for AllowHost or Scope to look
+			// at. Only keep them on the page if they were asked for.
+			links := page.Links
+			if state.Config.AllowHost != nil || state.Config.Scope != nil {
+				links = append(slices.Clip(links), page.ExternalLinks...)
+				if !state.Config.RecordExternalLinks {
+					page.ExternalLinks = nil
 				}
-
-				_, ok := site.Pages[link.Path]
-				if !ok {
-					// We have not already crawled this URL; create a placeholder
-					// so mulitple workers do not end up requesting the same link.
-					site.Pages[link.Path] = Webpage{}
-					state.Links <- link
+			}
+			page.PaginationGroup = backfill.PaginationGroup(&page.URL, state.Config.PaginationParams)
+			if how, ok := state.visited[key]; ok {
+				page.DiscoveredVia = how
+			}
+			page.Depth = state.depths[key]
+			page.MixedContent = nil
+			for _, issue := range FindMixedContent(page) {
+				page.MixedContent = append(page.MixedContent, issue.Asset)
+				site.MixedContent = append(site.MixedContent, issue)
+			}
+			for _, feed := range page.Feeds {
+				if state.feeds == nil {
+					state.feeds = make(map[string]bool)
+				}
+				if !state.feeds[feed.URL] {
+					state.feeds[feed.URL] = true
+					site.Feeds = append(site.Feeds, feed)
 				}
 			}
-		default:
-			select {
-			case <-state.Done:
-				break Loop
-			default:
-				// Tell the MonitorWorker that we currently have no work to do
-				if msg.Busy {
-					msg.Busy = false
-					state.Msgs <- msg
+			omit := page.NoIndex && state.Config.HonorNoIndex
+			if omit {
+				state.Config.Logf(LogDebug, "[%d] not indexing %s: noindex\n", id, page.URL.String())
+			} else {
+				site.Pages[key] = page
+				if state.Previous != nil && !page.NotModified {
+					site.Changed = append(site.Changed, key)
+				}
+				indexed := state.Stats.Indexed.Add(1)
+				state.Config.Logf(LogDebug, "[%d] indexed %s\n", id, page.URL.String())
+				if indexed%ProgressInterval == 0 {
+					state.Config.Logf(LogInfo, "indexed %d pages, %d queued, %d failed\n",
+						indexed, state.Links.Len(), state.Stats.Failures.Load())
+				}
+			}
+			if IsHeavy(page, state.Config.HeavyLinks, state.Config.HeavyAssets) {
+				state.Config.Logf(LogInfo, "[%d] warning: %s has %d links and %d assets\n",
+					id, page.URL.String(), len(page.Links), len(page.Assets))
+			}
+
+			// Check the links on the page to find out what to crawl next,
+			// unless the server or the config says not to follow them.
+			embedded := page.EmbeddedLinks
+			if page.NoFollow || state.Config.SeedsOnly || !follow {
+				links, embedded = nil, nil
+			}
+			forms := make(map[url.URL]bool)
+			for _, form := range page.Forms {
+				forms[form.Action] = true
+			}
+			frames := make(map[url.URL]bool)
+			for _, frame := range page.Frames {
+				frames[frame] = true
+			}
+			for _, link := range links {
+				how := DiscoveredLink
+				switch {
+				case link == page.NextPage || link == page.PrevPage:
+					how = DiscoveredPagination
+				case forms[link]:
+					how = DiscoveredForm
+				case frames[link]:
+					how = DiscoveredIframe
 				}
+				state.QueueLink(id, site, link, how, page.Depth+1)
+			}
+			// Embedded links go last, so a URL that's also a plain link is
+			// recorded as one.
+			for _, link := range embedded {
+				state.QueueLink(id, site, link, DiscoveredEmbedded, page.Depth+1)
+			}
+			state.Stats.Queued.Store(int64(state.Links.Len()))
+			state.SeedIndexed(site, key, page)
+
+			// Queue up the page's assets to be checked or scanned once.
+			for _, asset := range page.Assets {
+				state.QueueAsset(site, asset.URL)
+			}
+			state.settle(&page.URL)
+			site.mu.Unlock()
+			if state.Config.OnPage != nil && !omit {
+				state.Config.OnPage(state.Context, page)
+			}
+			state.Unindexed.Add(-1)
+		case check := <-state.Checks:
+			state.RecordCheck(site, check)
+		case sheet := <-state.Sheets:
+			state.RecordSheet(site, sheet)
+		case pageErr := <-state.Errors:
+			state.RecordError(id, site, pageErr)
+		case <-state.RequestsDone:
+			// Nothing new can arrive, so stop once what's buffered is indexed.
+			if len(state.Pages) == 0 && len(state.Errors) == 0 && len(state.Checks) == 0 &&
+				len(state.Sheets) == 0 {
+				state.Config.Logf(LogDebug, "[%d] stopped\n", id)
+				return
 			}
 		}
 	}
-	state.WG.Done()
 }
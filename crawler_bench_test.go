@@ -0,0 +1,79 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"crawler/testutil"
+)
+
+// benchConfig is the config every benchmark crawls with: no politeness delay
+// and a short debounce, so the numbers measure the crawler rather than the
+// time it spends waiting on purpose.
+func benchConfig() CrawlerConfig {
+	config := DefaultConfig()
+	config.Policy = CompositePolicy{}
+	config.DebounceTimeout = 50 * time.Millisecond
+	config.MaxIdle = 20 * time.Millisecond
+	config.LogLevel = LogError
+	return config
+}
+
+// benchCrawl crawls server b.N times and checks each crawl found every page.
+func benchCrawl(b *testing.B, server *httptest.Server, pages int, config CrawlerConfig) {
+	b.Helper()
+	seed := testutil.SeedURL(server)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		site := CrawlerWithConfig(seed, config)
+		if len(site.Pages) != pages {
+			b.Fatalf("crawled %d pages, want %d", len(site.Pages), pages)
+		}
+	}
+	b.ReportMetric(float64(pages), "pages/op")
+}
+
+// BenchmarkCrawlSynthetic crawls SyntheticSite at several sizes and fan-outs,
+// served with no latency, to measure the crawler's own overhead.
+func BenchmarkCrawlSynthetic(b *testing.B) {
+	for _, size := range []struct{ pages, fanout int }{
+		{100, 5},
+		{1000, 5},
+		{1000, 50},
+	} {
+		b.Run(fmt.Sprintf("pages=%d/fanout=%d", size.pages, size.fanout), func(b *testing.B) {
+			server := testutil.FixtureSite(testutil.SyntheticSite(size.pages, size.fanout))
+			defer server.Close()
+			benchCrawl(b, server, size.pages+1, benchConfig())
+		})
+	}
+}
+
+// BenchmarkCrawlLatency crawls SyntheticSite behind LatencySite, where the
+// worker pool rather than the crawler's overhead sets the pace.
+func BenchmarkCrawlLatency(b *testing.B) {
+	for _, latency := range []time.Duration{time.Millisecond, 10 * time.Millisecond} {
+		b.Run(fmt.Sprintf("latency=%s", latency), func(b *testing.B) {
+			server := testutil.LatencySite(testutil.SyntheticSite(200, 5), latency)
+			defer server.Close()
+			benchCrawl(b, server, 201, benchConfig())
+		})
+	}
+}
+
+// BenchmarkCrawlIndexBuffer crawls the same site with different IndexBuffer
+// sizes, to see how much the buffer between the RequestWorkers and the
+// IndexWorker matters.
+func BenchmarkCrawlIndexBuffer(b *testing.B) {
+	for _, size := range []int{1, 10, IndexBufferSize} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			server := testutil.LatencySite(testutil.SyntheticSite(200, 5), time.Millisecond)
+			defer server.Close()
+			config := benchConfig()
+			config.IndexBuffer = size
+			benchCrawl(b, server, 201, config)
+		})
+	}
+}
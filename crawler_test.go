@@ -0,0 +1,507 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"crawler/testutil"
+)
+
+// pageKeys gets the keys of the crawled pages, sorted, for failure messages.
+func pageKeys(site *Website) []string {
+	keys := make([]string, 0, len(site.Pages))
+	for key := range site.Pages {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// hostPolicy is a Policy that records the hosts it's asked about.
+type hostPolicy struct {
+	mu    sync.Mutex
+	hosts map[string]bool
+}
+
+func (p *hostPolicy) Acquire(ctx context.Context, link *url.URL) (func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hosts[link.Host] = true
+	return func() {}, nil
+}
+
+func TestHostRewrite(t *testing.T) {
+	var log testutil.RequestLog
+	server := httptest.NewServer(log.Wrap(testutil.FixtureHandler(map[string]string{
+		"/":         `<a href="http://example.test/about">About</a><img src="http://example.test/logo.png">`,
+		"/about":    `<a href="http://example.test/">Home</a>`,
+		"/logo.png": ``})))
+	defer server.Close()
+	staging := testutil.SeedURL(server)
+
+	policy := &hostPolicy{hosts: make(map[string]bool)}
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.HostRewrite = map[string]string{"example.test": staging.Host}
+	config.Policy = policy
+	config.ValidateAssets = true
+	site := CrawlerWithConfig(staging, config)
+
+	// The pages only link to production, so anything that reaches the
+	// staging server or the policy without being rewritten goes missing.
+	if _, ok := site.Pages["/about"]; !ok {
+		t.Errorf("pages = %v, want example.test/about crawled from staging", pageKeys(site))
+	}
+	if !log.Requested("/logo.png") {
+		t.Errorf("requests = %v, want the asset checked on staging", log.Paths())
+	}
+	if len(policy.hosts) != 1 || !policy.hosts[staging.Host] {
+		t.Errorf("policy saw hosts %v, want only %s", policy.hosts, staging.Host)
+	}
+}
+
+func TestMergeWebsites(t *testing.T) {
+	// Two partial crawls of staging, whose pages link to production. Each
+	// gave up on a link the other fetched, so only the HostRewrite can match
+	// them up.
+	staging := url.URL{Scheme: "http", Host: "127.0.0.1:8080", Path: "/"}
+	production := func(path string) url.URL {
+		return url.URL{Scheme: "http", Host: "example.test", Path: path}
+	}
+	rewrite := map[string]string{"example.test": staging.Host}
+	first := &Website{
+		Domain: staging,
+		Pages:  map[string]Webpage{"/": {URL: staging}},
+		Errors: []WebpageError{{URL: production("/b"), Err: "500 Internal Server Error", StatusCode: 500}},
+		Skipped: []SkipError{
+			{"http://example.test/feed.json", "not HTML"},
+			{"http://example.test/b", "out of scope"}},
+		Dropped:     []url.URL{production("/b"), production("/c")},
+		Stats:       &CrawlStats{},
+		hostRewrite: rewrite}
+	first.Stats.Requests.Store(3)
+	first.Stats.Bytes.Store(100)
+	second := &Website{
+		Domain:      staging,
+		Pages:       map[string]Webpage{"/b": {URL: production("/b")}},
+		Skipped:     []SkipError{{"http://example.test/feed.json", "not HTML"}},
+		Dropped:     []url.URL{production("/c")},
+		Stats:       &CrawlStats{},
+		hostRewrite: rewrite}
+	second.Stats.Requests.Store(2)
+	second.Stats.Bytes.Store(50)
+
+	merged, err := MergeWebsites(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := pageKeys(merged); !slices.Equal(keys, []string{"/", "/b"}) {
+		t.Errorf("pages = %v, want / and /b", keys)
+	}
+	if key := merged.PageKey(&url.URL{Scheme: "http", Host: "example.test", Path: "/b"}); key != "/b" {
+		t.Errorf("PageKey(example.test/b) = %q, want the HostRewrite applied", key)
+	}
+	if len(merged.Errors) != 0 {
+		t.Errorf("errors = %v, want /b's dropped now it was fetched", merged.Errors)
+	}
+	if len(merged.Skipped) != 1 || merged.Skipped[0].URL != "http://example.test/feed.json" {
+		t.Errorf("skipped = %v, want feed.json once", merged.Skipped)
+	}
+	if len(merged.Dropped) != 1 || merged.Dropped[0].Path != "/c" {
+		t.Errorf("dropped = %v, want /c once", merged.Dropped)
+	}
+	if requests := merged.Stats.Requests.Load(); requests != 5 {
+		t.Errorf("Stats.Requests = %d, want 5", requests)
+	}
+	if downloaded := merged.Stats.Bytes.Load(); downloaded != 150 {
+		t.Errorf("Stats.Bytes = %d, want 150", downloaded)
+	}
+}
+
+func TestRequestRecorder(t *testing.T) {
+	server := testutil.FixtureSite(map[string]string{
+		"/robots.txt": "User-agent: *\nDisallow: /private\n",
+		"/": `<a href="/about">About</a><a href="/private">Private</a>` +
+			`<a href="/blog/post">Blog</a><a href="http://other.example/">Other</a>` +
+			`<img src="/logo.png">`,
+		"/about":     `<a href="/">Home</a>`,
+		"/private":   `<a href="/">Home</a>`,
+		"/blog/post": `<a href="/">Home</a>`,
+		"/logo.png":  ``})
+	defer server.Close()
+	seed := testutil.SeedURL(server)
+
+	var recorder RequestRecorder
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.ValidateAssets = true
+	config.Recorder = &recorder
+	config.Scope = CompositeScope{
+		SameHostScope{Domain: seed},
+		ScopeFunc(func(link url.URL) bool { return !strings.HasPrefix(link.Path, "/blog/") })}
+	CrawlerWithConfig(seed, config)
+
+	for _, path := range []string{"/", "/about", "/logo.png"} {
+		if !recorder.Requested(server.URL + path) {
+			t.Errorf("recorded %v, want %s", recorder.URLs(), path)
+		}
+	}
+	for _, link := range []string{server.URL + "/private", server.URL + "/blog/post", "http://other.example/"} {
+		if recorder.Requested(link) {
+			t.Errorf("recorded %v, want %s held back", recorder.URLs(), link)
+		}
+	}
+	if urls := recorder.URLs(); len(urls) != 3 {
+		t.Errorf("recorded %v, want only the pages and asset fetched", urls)
+	}
+}
+
+func TestWorkersLogStop(t *testing.T) {
+	server := testutil.FixtureSite(map[string]string{
+		"/":      `<a href="/about">About</a>`,
+		"/about": `<a href="/">Home</a>`})
+	defer server.Close()
+
+	var logs bytes.Buffer
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.LogLevel = LogDebug
+	config.Logger = log.New(&logs, "", 0)
+	CrawlerWithConfig(testutil.SeedURL(server), config)
+
+	// Every worker, the IndexWorker included, says it stopped exactly once.
+	for id := 0; id <= NumWorkers; id += 1 {
+		line := fmt.Sprintf("[%d] stopped\n", id)
+		if count := strings.Count(logs.String(), line); count != 1 {
+			t.Errorf("%q logged %d times, want once", strings.TrimSpace(line), count)
+		}
+	}
+}
+
+func TestShouldTerminate(t *testing.T) {
+	config := &CrawlerConfig{DebounceTimeout: 500 * time.Millisecond, MaxIdle: 200 * time.Millisecond}
+	free := make(map[int]bool, NumWorkers)
+	for id := 0; id < NumWorkers; id += 1 {
+		free[id] = false
+	}
+	busy := make(map[int]bool, NumWorkers)
+	for id := range free {
+		busy[id] = id == 3
+	}
+	tests := []struct {
+		name    string
+		workers map[int]bool
+		queued  int
+		free    time.Duration
+		drained time.Duration
+		want    bool
+	}{
+		{"not all reported", map[int]bool{0: false}, 0, time.Second, time.Second, false},
+		{"one busy", busy, 0, time.Second, time.Second, false},
+		// Right after the root is indexed every worker is free for a
+		// moment while its links are still on their way to the frontier.
+		{"free for a moment, links queued", free, 5, 10 * time.Millisecond, 0, false},
+		{"free for a moment, nothing queued", free, 0, 10 * time.Millisecond, 10 * time.Millisecond, false},
+		{"free past the debounce", free, 5, 500 * time.Millisecond, 0, true},
+		{"free and drained past MaxIdle", free, 0, 200 * time.Millisecond, 200 * time.Millisecond, true},
+		{"free past MaxIdle with links queued", free, 5, 200 * time.Millisecond, 0, false},
+	}
+	for _, test := range tests {
+		if got := ShouldTerminate(test.workers, test.queued, test.free, test.drained, config); got != test.want {
+			t.Errorf("%s: ShouldTerminate = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDebounceAfterRoot(t *testing.T) {
+	server := testutil.FixtureSite(map[string]string{
+		"/":           `<a href="/about">About</a>`,
+		"/about":      `<a href="/about/team">Team</a>`,
+		"/about/team": `<a href="/">Home</a>`})
+	defer server.Close()
+
+	// Holding the root back in FollowIf leaves every worker free with an
+	// empty frontier until its links are queued, which mustn't end the crawl.
+	config := DefaultConfig()
+	config.DebounceTimeout = 400 * time.Millisecond
+	config.FollowIf = func(_ context.Context, page Webpage) bool {
+		if page.URL.Path == "/" {
+			time.Sleep(150 * time.Millisecond)
+		}
+		return true
+	}
+	site := CrawlerWithConfig(testutil.SeedURL(server), config)
+
+	for _, key := range []string{"/", "/about", "/about/team"} {
+		if _, ok := site.Pages[key]; !ok {
+			t.Errorf("pages = %v, want %s crawled", site.Snapshot().Pages, key)
+		}
+	}
+}
+
+func TestMalformedPages(t *testing.T) {
+	server := testutil.FixtureSite(testutil.MalformedPages)
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	site := CrawlerWithConfig(testutil.SeedURL(server), config)
+
+	// A panic while parsing would be recovered and show up as an error.
+	if len(site.Errors) > 0 {
+		t.Errorf("errors = %v, want none", site.Errors)
+	}
+	want := map[string]string{
+		"/":      "/one /two",
+		"/one":   "/ /two",
+		"/two":   "/three",
+		"/three": ""}
+	if len(site.Pages) != len(want) {
+		t.Errorf("pages = %v, want %d", pageKeys(site), len(want))
+	}
+	for key, links := range want {
+		page, ok := site.Pages[key]
+		if !ok {
+			t.Errorf("%s wasn't crawled", key)
+			continue
+		}
+		paths := make([]string, len(page.Links))
+		for i, link := range page.Links {
+			paths[i] = link.Path
+		}
+		if got := strings.Join(paths, " "); got != links {
+			t.Errorf("%s links = %q, want %q", key, got, links)
+		}
+	}
+}
+
+func TestDiscoveredVia(t *testing.T) {
+	server := testutil.FixtureSite(map[string]string{
+		"/": `<a href="/about">About</a><iframe src="/embed"></iframe>` +
+			`<form action="/search"><input name="q"></form><link rel="next" href="/2">`,
+		"/about":  `<a href="/">Home</a>`,
+		"/embed":  `<p>Embedded</p>`,
+		"/search": `<p>Results</p>`,
+		"/2":      `<a href="/">Home</a>`})
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	site := CrawlerWithConfig(testutil.SeedURL(server), config)
+
+	want := map[string]Discovery{
+		"/":       DiscoveredSeed,
+		"/about":  DiscoveredLink,
+		"/embed":  DiscoveredIframe,
+		"/search": DiscoveredForm,
+		"/2":      DiscoveredPagination}
+	for key, how := range want {
+		if page, ok := site.Pages[key]; !ok {
+			t.Errorf("%s wasn't crawled, pages = %v", key, pageKeys(site))
+		} else if page.DiscoveredVia != how {
+			t.Errorf("%s discovered via %s, want %s", key, page.DiscoveredVia, how)
+		}
+	}
+}
+
+func TestStreamLinks(t *testing.T) {
+	server := testutil.FixtureSite(map[string]string{
+		"/": `<a href="/about">About</a><iframe src="/embed"></iframe><a href="/missing">Gone</a>` +
+			`<form action="/search"><input name="q"></form><link rel="next" href="/2">`,
+		"/about":  `<a href="/">Home</a>`,
+		"/embed":  `<p>Embedded</p>`,
+		"/search": `<p>Results</p>`,
+		"/2":      `<a href="/">Home</a>`})
+	defer server.Close()
+
+	// With a frontier of one under FrontierBlock, most of the root's links
+	// find it full and have to wait for the IndexWorker.
+	for _, max := range []int{0, 1} {
+		config := DefaultConfig()
+		config.DebounceTimeout = 200 * time.Millisecond
+		config.StreamLinks = true
+		config.MaxFrontier = max
+		site := CrawlerWithConfig(testutil.SeedURL(server), config)
+
+		want := map[string]Discovery{
+			"/":       DiscoveredSeed,
+			"/about":  DiscoveredLink,
+			"/embed":  DiscoveredIframe,
+			"/search": DiscoveredForm,
+			"/2":      DiscoveredPagination}
+		for key, how := range want {
+			if page, ok := site.Pages[key]; !ok {
+				t.Errorf("MaxFrontier %d: %s wasn't crawled, pages = %v", max, key, pageKeys(site))
+			} else if page.DiscoveredVia != how {
+				t.Errorf("MaxFrontier %d: %s discovered via %s, want %s", max, key, page.DiscoveredVia, how)
+			}
+		}
+		if max == 0 && len(site.Pages["/"].Links) != 0 {
+			t.Errorf("/ kept its streamed links %v", site.Pages["/"].Links)
+		}
+		if len(site.Dropped) > 0 {
+			t.Errorf("MaxFrontier %d: dropped %v", max, site.Dropped)
+		}
+		if broken := site.BrokenLinks(); len(broken) != 1 || broken[0].URL.Path != "/missing" {
+			t.Errorf("MaxFrontier %d: broken links = %v, want /missing", max, broken)
+		}
+	}
+}
+
+// panicPolicy is a Policy that panics, to make every request blow up.
+type panicPolicy struct{}
+
+func (panicPolicy) Acquire(ctx context.Context, link *url.URL) (func(), error) {
+	panic("policy broke")
+}
+
+func TestSeedFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/home", http.StatusFound)
+			return
+		}
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	seed := testutil.SeedURL(server)
+	at := func(path string) url.URL {
+		link := seed
+		link.Path = path
+		return link
+	}
+
+	for _, test := range []struct {
+		name   string
+		seeds  []url.URL
+		config func(*CrawlerConfig)
+		failed bool
+	}{
+		{"all seeds 500", []url.URL{at("/a"), at("/b")}, func(*CrawlerConfig) {}, true},
+		{"seed redirects to a 500", []url.URL{at("/")}, func(config *CrawlerConfig) {
+			config.RedirectPolicy = FollowNoRedirects
+		}, true},
+		{"seed panics", []url.URL{at("/a")}, func(config *CrawlerConfig) {
+			config.Policy = panicPolicy{}
+		}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Policy = CompositePolicy{}
+			config.LogLevel = LogError
+			config.DebounceTimeout = time.Second
+			test.config(&config)
+
+			start := time.Now()
+			site := CrawlerSeedsWithConfig(test.seeds, config)
+			elapsed := time.Since(start)
+
+			if got := errors.Is(site.Err, ErrSeedFailed); got != test.failed {
+				t.Errorf("site.Err = %v, want ErrSeedFailed: %t", site.Err, test.failed)
+			}
+			if test.failed && elapsed >= config.DebounceTimeout {
+				t.Errorf("crawl took %s, want it to stop before the %s debounce", elapsed, config.DebounceTimeout)
+			}
+		})
+	}
+}
+
+func TestWorkersAndMaxDepth(t *testing.T) {
+	server := testutil.FixtureSite(map[string]string{
+		"/":  `<a href="/1">One</a>`,
+		"/1": `<a href="/2">Two</a>`,
+		"/2": `<a href="/3">Three</a>`,
+		"/3": `<a href="/">Home</a>`})
+	defer server.Close()
+
+	stats := &CrawlStats{}
+	site, err := CrawlerWithOptions(testutil.SeedURL(server),
+		WithWorkers(3), WithMaxDepth(2), WithStats(stats), WithDebounce(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := stats.Workers.Load(); got != 3 {
+		t.Errorf("crawled with %d workers, want 3", got)
+	}
+	if got := strings.Join(pageKeys(site), " "); got != "/ /1 /2" {
+		t.Errorf("pages = %q, want %q", got, "/ /1 /2")
+	}
+	for key, depth := range map[string]int{"/": 0, "/1": 1, "/2": 2} {
+		if got := site.Pages[key].Depth; got != depth {
+			t.Errorf("%s depth = %d, want %d", key, got, depth)
+		}
+	}
+}
+
+func TestDefaultConfigSkipExtensions(t *testing.T) {
+	want := slices.Clone(SkipExtensions)
+	config := DefaultConfig()
+	config.SkipExtensions[0] = "html"
+	if !slices.Equal(SkipExtensions, want) {
+		t.Errorf("changing a config's SkipExtensions changed the package's to %v", SkipExtensions)
+	}
+	if got := DefaultConfig().SkipExtensions; !slices.Equal(got, want) {
+		t.Errorf("DefaultConfig().SkipExtensions = %v, want %v", got, want)
+	}
+}
+
+func TestAssetHosts(t *testing.T) {
+	server := testutil.FixtureSite(map[string]string{
+		"/": `<a href="/about">About</a><img src="//cdn.example.net/a.png">` +
+			`<script src="https://cdn.example.net/b.js"></script><img src="/local.png">`,
+		"/about": `<img src="//cdn.example.net/a.png"><img src="//fonts.example.org/f.woff2">`})
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.IncludeExternalAssets = true
+	site := CrawlerWithConfig(testutil.SeedURL(server), config)
+
+	// Protocol-relative assets are the usual way to load from a CDN.
+	want := map[string]int{"cdn.example.net": 2, "fonts.example.org": 1}
+	if got := site.AssetHosts(); !maps.Equal(got, want) {
+		t.Errorf("AssetHosts = %v, want %v", got, want)
+	}
+}
+
+func TestParseNoscript(t *testing.T) {
+	server := testutil.FixtureSite(map[string]string{
+		"/":         `<a href="/live">Live</a><noscript><a href="/fallback">Fallback</a></noscript>`,
+		"/live":     `<a href="/">Home</a>`,
+		"/fallback": `<a href="/">Home</a>`})
+	defer server.Close()
+
+	for _, test := range []struct {
+		noscript, stream bool
+		pages            string
+	}{
+		// Fallback links are ignored unless asked for, since they mostly
+		// repeat the scripted page's.
+		{false, false, "/ /live"},
+		{false, true, "/ /live"},
+		{true, false, "/ /fallback /live"},
+		{true, true, "/ /fallback /live"},
+	} {
+		config := DefaultConfig()
+		config.DebounceTimeout = 200 * time.Millisecond
+		config.ParseNoscript = test.noscript
+		config.StreamLinks = test.stream
+		site := CrawlerWithConfig(testutil.SeedURL(server), config)
+		if got := strings.Join(pageKeys(site), " "); got != test.pages {
+			t.Errorf("ParseNoscript %v, StreamLinks %v: pages = %q, want %q", test.noscript, test.stream, got, test.pages)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"time"
+
+	"crawler/backfill"
+)
+
+// SkipError is returned when a page was deliberately not fetched, e.g. because
+// its content type isn't one of the crawlable ones.
+type SkipError struct {
+	URL    string
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return fmt.Sprintf("skipped %s: %s", e.URL, e.Reason)
+}
+
+// CrawlPage fetches and parses a single page. The config's ResponsePolicy
+// decides whether a response is parsed, skipped, retried or stops the crawl.
+// A failed request or a skipped error status is returned as a WebpageError, a
+// stop as a StopError, and a page skipped for any other reason as a SkipError.
+// If previous is set and the server says the page hasn't changed since, the
+// previous page is returned with NotModified set.
+func CrawlPage(ctx context.Context, link url.URL, config *CrawlerConfig, previous *Webpage) (Webpage, error) {
+	return CrawlPageStream(ctx, link, config, previous, nil)
+}
+
+// FetchPage fetches and parses a single page without crawling any further, using
+// the config's client, headers and ResponsePolicy. The config's Policy is
+// honored too, so a URL robots.txt disallows fails with ErrDisallowed.
+func FetchPage(link url.URL, config CrawlerConfig) (Webpage, error) {
+	ctx := context.Background()
+	if policy, ok := config.Policy.(ConfigurablePolicy); ok {
+		policy.Configure(&config)
+	}
+	if config.Policy != nil {
+		fetch := backfill.RewriteHost(&link, config.HostRewrite)
+		release, err := config.Policy.Acquire(ctx, &fetch)
+		if err != nil {
+			return Webpage{}, err
+		}
+		defer release()
+	}
+	return CrawlPage(ctx, link, &config, nil)
+}
+
+// CrawlPageStream is CrawlPage, except that if onLink is set the page is
+// tokenized as it's read (see backfill.StreamDocument) and each same-host link
+// is passed to onLink, along with how it was found, instead of being collected
+// on the returned page's Links. Links on a nofollow page are neither passed on
+// nor kept.
+func CrawlPageStream(ctx context.Context, link url.URL, config *CrawlerConfig, previous *Webpage, onLink func(url.URL, Discovery)) (Webpage, error) {
+	responsePolicy := config.ResponsePolicy
+	switch {
+	case responsePolicy != nil:
+	case config.RetryStatuses != nil:
+		responsePolicy = RetryStatusPolicy(config.RetryStatuses)
+	default:
+		responsePolicy = DefaultResponsePolicy
+	}
+	// The page keeps its logical URL but is fetched from the rewritten host.
+	fetch := backfill.RewriteHost(&link, config.HostRewrite)
+
+	var response *http.Response
+	var start time.Time
+	// Each attempt gets its own deadline covering the headers and the body, so
+	// a server that drips a response forever can't hold on to a worker.
+	attempt, cancel := ctx, context.CancelFunc(func() {})
+	defer func() { cancel() }()
+	for retries := 0; ; retries++ {
+		var err error
+		cancel()
+		if config.ResponseTimeout > 0 {
+			var timeout context.CancelFunc
+			attempt, timeout = context.WithTimeout(ctx, config.ResponseTimeout)
+			cancel = timeout
+		}
+		start = time.Now()
+		response, err = GetPage(attempt, &fetch, config, previous)
+		if err != nil {
+			if _, ok := err.(*SkipError); ok {
+				return Webpage{}, err
+			}
+			if pageErr, ok := err.(WebpageError); ok {
+				// A HEAD request with HeadFirst failed, see GetPage.
+				pageErr.URL = link
+				pageErr.Retries = retries
+				return Webpage{}, pageErr
+			}
+			var redirect *RedirectError
+			if errors.As(err, &redirect) {
+				return Webpage{}, WebpageError{URL: link, Err: redirect.Error(), Retries: retries, Redirects: redirect.Chain}
+			}
+			return Webpage{}, WebpageError{URL: link, Err: err.Error(), Retries: retries}
+		}
+		if response.StatusCode == http.StatusNotModified && previous != nil {
+			response.Body.Close()
+			page := *previous
+			page.NotModified = true
+			return page, nil
+		}
+
+		action := responsePolicy(ctx, response)
+		if action == Parse {
+			break
+		}
+		response.Body.Close()
+
+		pageErr := WebpageError{URL: link, Err: response.Status, StatusCode: response.StatusCode, Retries: retries}
+		switch action {
+		case Stop:
+			return Webpage{}, &StopError{pageErr}
+		case Retry:
+			if config.RetryStatuses != nil && !slices.Contains(config.RetryStatuses, response.StatusCode) {
+				return Webpage{}, pageErr
+			}
+			if retries < config.MaxRetries && Backoff(ctx, config.RetryBackoff, retries) == nil {
+				continue
+			}
+			return Webpage{}, pageErr
+		}
+		if response.StatusCode >= 400 {
+			return Webpage{}, pageErr
+		}
+		return Webpage{}, &SkipError{link.String(), response.Status}
+	}
+	defer response.Body.Close()
+	// The client only hands back a redirect it was told not to follow.
+	// Record where it leads and let the target be crawled (or not) like
+	// any other link.
+	if location, err := response.Location(); err == nil && response.StatusCode >= 300 && response.StatusCode < 400 {
+		if location.Host == fetch.Host {
+			location.Host = link.Host
+		}
+		page := Webpage{
+			URL:           link,
+			StatusCode:    response.StatusCode,
+			FetchDuration: time.Since(start),
+			RedirectURL:   *location}
+		if backfill.SameHost(location, &link) {
+			page.Links = []url.URL{*location}
+		} else {
+			page.ExternalLinks = []url.URL{*location}
+		}
+		return page, nil
+	}
+	// A redirect to the login page means we aren't allowed to see this one.
+	// Record that instead of parsing the login form over and over.
+	if final := response.Request.URL; final.Path != fetch.Path && IsLoginPath(final.Path, config.LoginPaths) {
+		page := Webpage{
+			URL:           link,
+			StatusCode:    response.StatusCode,
+			FetchDuration: time.Since(start),
+			RedirectURL:   *final,
+			AuthRequired:  true}
+		return page, nil
+	}
+	if !backfill.HasContentType(response, config.ContentTypes()) {
+		return Webpage{}, &SkipError{link.String(), "not crawlable: " + response.Header.Get("Content-Type")}
+	}
+
+	// readFailed records a body that couldn't be read in full as a failed page.
+	readFailed := func(err error) error {
+		msg := err.Error()
+		if attempt.Err() == context.DeadlineExceeded {
+			msg = fmt.Sprintf("timed out reading response after %s", config.ResponseTimeout)
+		}
+		return WebpageError{URL: link, Err: msg, StatusCode: response.StatusCode}
+	}
+
+	counter := config.CountBytes(response.Body)
+	var body io.Reader = counter
+	var limited *io.LimitedReader
+	if config.MaxBodySize > 0 {
+		limited = &io.LimitedReader{R: body, N: config.MaxBodySize}
+		body = limited
+	}
+	var hasher hash.Hash
+	if config.HashContent {
+		// Hash the body as the parser reads it rather than buffering it.
+		hasher = sha256.New()
+		body = io.TeeReader(body, hasher)
+	}
+	var raw []byte
+	if config.KeepBody {
+		var err error
+		raw, err = io.ReadAll(body)
+		if counter.Limited {
+			return Webpage{}, &SkipError{link.String(), ErrMaxBytes.Error()}
+		}
+		if err != nil {
+			return Webpage{}, readFailed(err)
+		}
+		body = bytes.NewReader(raw)
+	}
+	tag := backfill.ParseRobotsTag(response.Header.Values("X-Robots-Tag"), config.UserAgent)
+	options := config.ParseOptions()
+	if onLink != nil {
+		options.OnLink = func(found url.URL, kind backfill.LinkKind) {
+			if !tag.NoFollow {
+				onLink(found, LinkDiscovery(kind))
+			}
+		}
+	}
+	// Decode on top of the hashing and KeepBody, so those get the raw bytes.
+	decoded := backfill.DecodeCharset(body, response.Header.Get("Content-Type"))
+	doc := backfill.ParseDocument(response.Request.URL, decoded, options)
+	var contentHash string
+	if hasher != nil {
+		// The parser can stop early on broken markup; hash whatever it left.
+		if _, err := io.Copy(io.Discard, body); err != nil && !counter.Limited {
+			return Webpage{}, readFailed(err)
+		}
+		contentHash = hex.EncodeToString(hasher.Sum(nil))
+	}
+	// Half a page is no use, and the crawl is about to stop anyway.
+	if counter.Limited {
+		return Webpage{}, &SkipError{link.String(), ErrMaxBytes.Error()}
+	}
+	// The parser treats a read error as the end of the document, so check
+	// whether the deadline cut it short.
+	if attempt.Err() == context.DeadlineExceeded {
+		return Webpage{}, readFailed(attempt.Err())
+	}
+	// A body that used up the whole limit was cut short if there's more.
+	truncated := false
+	if limited != nil && limited.N == 0 {
+		_, err := io.ReadFull(limited.R, make([]byte, 1))
+		truncated = err == nil
+	}
+	if truncated {
+		config.Logf(LogInfo, "warning: %s is over %d bytes, only the start was parsed\n", link.String(), config.MaxBodySize)
+	}
+	page := Webpage{
+		URL:            link,
+		StatusCode:     response.StatusCode,
+		FetchDuration:  time.Since(start),
+		Title:          doc.Title,
+		Lang:           doc.Lang,
+		NextPage:       doc.NextPage,
+		PrevPage:       doc.PrevPage,
+		EmbeddedLinks:  doc.EmbeddedLinks,
+		RefreshURL:     doc.RefreshURL,
+		Feeds:          doc.Feeds,
+		Frames:         doc.Frames,
+		Links:          doc.Links,
+		Assets:         doc.Assets,
+		StructuredData: doc.StructuredData,
+		Forms:          doc.Forms,
+		OtherLinks:     doc.OtherLinks,
+		ExternalLinks:  doc.ExternalLinks,
+		ETag:           response.Header.Get("ETag"),
+		LastModified:   response.Header.Get("Last-Modified"),
+		Body:           raw,
+		BodyTruncated:  truncated,
+		ContentHash:    contentHash}
+	if final := response.Request.URL; final.String() != fetch.String() {
+		page.RedirectURL = *final
+	}
+	page.NoIndex, page.NoFollow = tag.NoIndex, tag.NoFollow
+	return page, nil
+}
+
+// LinkDiscovery gets the Discovery for a link the parser found as kind.
+func LinkDiscovery(kind backfill.LinkKind) Discovery {
+	switch kind {
+	case backfill.LinkForm:
+		return DiscoveredForm
+	case backfill.LinkFrame:
+		return DiscoveredIframe
+	case backfill.LinkPagination:
+		return DiscoveredPagination
+	}
+	return DiscoveredLink
+}
+
+// IsLoginPath determines if a path matches one of the login path patterns, which
+// use path.Match syntax, e.g. "/login" or "/accounts/*/signin".
+func IsLoginPath(p string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPage fetches a page for parsing. With HeadFirst set it sends a HEAD request
+// first and only GETs the body if the response is a 2xx page of a crawlable
+// content type: an error status is returned as a WebpageError, like it would be
+// for the GET, and any other content type as a SkipError. Servers that reject
+// HEAD with 405 or 501 get a plain GET instead. If previous is set, the GET is
+// made conditional on the page having changed since it was crawled.
+func GetPage(ctx context.Context, link *url.URL, config *CrawlerConfig, previous *Webpage) (*http.Response, error) {
+	if config.HeadFirst {
+		request, err := NewRequest(ctx, http.MethodHead, link, config)
+		if err != nil {
+			return nil, err
+		}
+		head, err := config.HTTPClient().Do(request)
+		if err != nil {
+			return nil, err
+		}
+		head.Body.Close()
+
+		switch {
+		case head.StatusCode == http.StatusMethodNotAllowed, head.StatusCode == http.StatusNotImplemented:
+			// HEAD isn't supported; fall through to a normal GET.
+		case head.StatusCode >= 300 && head.StatusCode < 400:
+			// A redirect the client didn't follow; the GET records it.
+		case head.StatusCode >= 400:
+			return nil, WebpageError{URL: *link, Err: head.Status, StatusCode: head.StatusCode}
+		case !backfill.HasContentType(head, config.ContentTypes()):
+			return nil, &SkipError{link.String(), "not crawlable: " + head.Header.Get("Content-Type")}
+		}
+	}
+
+	request, err := NewRequest(ctx, http.MethodGet, link, config)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		if previous.ETag != "" {
+			request.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			request.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+	return config.HTTPClient().Do(request)
+}
+
+// NewRequest builds a request with the configured headers attached.
+func NewRequest(ctx context.Context, method string, link *url.URL, config *CrawlerConfig) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, link.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if config.UserAgent != "" {
+		request.Header.Set("User-Agent", config.UserAgent)
+	}
+	if config.AcceptLanguage != "" {
+		request.Header.Set("Accept-Language", config.AcceptLanguage)
+	}
+	for key, val := range config.Headers {
+		request.Header.Set(key, val)
+	}
+	return request, nil
+}
+
+// CheckAsset requests an asset to see if it loads. It sends a HEAD request so the
+// body isn't downloaded, falling back to GET for servers that don't support HEAD.
+// Like pages, assets are requested from the host after HostRewrite but checked
+// under their own URL.
+func CheckAsset(ctx context.Context, link *url.URL, config *CrawlerConfig) AssetCheck {
+	check := AssetCheck{URL: link.String()}
+	fetch := backfill.RewriteHost(link, config.HostRewrite)
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		request, err := NewRequest(ctx, method, &fetch, config)
+		if err != nil {
+			check.Err = err.Error()
+			return check
+		}
+		response, err := config.HTTPClient().Do(request)
+		if err != nil {
+			check.Err = err.Error()
+			return check
+		}
+		response.Body.Close()
+
+		check.StatusCode = response.StatusCode
+		if response.StatusCode != http.StatusMethodNotAllowed && response.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+	return check
+}
@@ -0,0 +1,81 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"crawler/testutil"
+)
+
+func TestHeadFirst(t *testing.T) {
+	pages := testutil.FixtureHandler(map[string]string{
+		"/":      `<a href="/about">About</a><a href="/gone">Gone</a><a href="/report">Report</a>`,
+		"/about": `<a href="/">Home</a>`})
+	var mu sync.Mutex
+	var gets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			gets = append(gets, r.URL.Path)
+			mu.Unlock()
+		}
+		if r.URL.Path == "/report" {
+			w.Header().Set("Content-Type", "application/pdf")
+			return
+		}
+		pages.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.HeadFirst = true
+	site := CrawlerWithConfig(testutil.SeedURL(server), config)
+
+	// A 404 on the HEAD is a broken link like a 404 on the GET would be,
+	// while a PDF is only skipped.
+	broken := site.BrokenLinks()
+	if len(broken) != 1 || broken[0].URL.Path != "/gone" || broken[0].StatusCode != http.StatusNotFound {
+		t.Errorf("broken links = %v, want /gone (404)", broken)
+	}
+	if len(site.Skipped) != 1 || !strings.HasSuffix(site.Skipped[0].URL, "/report") {
+		t.Errorf("skipped = %v, want /report", site.Skipped)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(gets, " "); strings.Contains(got, "/gone") || strings.Contains(got, "/report") {
+		t.Errorf("GET requests = %s, want none after a failed HEAD", got)
+	}
+}
+
+func TestMaxBodySizeTruncated(t *testing.T) {
+	long := `<a href="/first">First</a>` + strings.Repeat(" ", 100) + `<a href="/last">Last</a>`
+	server := testutil.FixtureSite(map[string]string{"/": long})
+	defer server.Close()
+
+	for _, test := range []struct {
+		max       int64
+		links     int
+		truncated bool
+	}{
+		{0, 2, false},
+		{int64(len(long)), 2, false},
+		{50, 1, true},
+	} {
+		config := DefaultConfig()
+		config.MaxBodySize = test.max
+		page, err := CrawlPage(context.Background(), testutil.SeedURL(server), &config, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Links) != test.links || page.BodyTruncated != test.truncated {
+			t.Errorf("MaxBodySize %d: %d links, truncated %v, want %d, %v",
+				test.max, len(page.Links), page.BodyTruncated, test.links, test.truncated)
+		}
+	}
+}
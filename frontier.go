@@ -0,0 +1,156 @@
+package crawler
+
+import (
+	"net/url"
+	"sync"
+)
+
+// Strategy picks the order links are crawled in.
+//
+// BreadthFirst crawls pages level by level out from the seed. The frontier holds
+// the entire next level at once, which on wide sites (big nav menus, archives)
+// can be most of the site before the first deep page is reached.
+//
+// DepthFirst always crawls the most recently found link next, so it reaches deep
+// content quickly. The frontier only grows by the links of each page along the
+// current path, but on sites with a long chain of pages (pagination, "next"
+// links) it keeps every sibling of every page on the chain waiting until the
+// chain ends.
+type Strategy int
+
+const (
+	BreadthFirst Strategy = iota
+	DepthFirst
+)
+
+// FrontierPolicy decides what happens to new links once the frontier holds
+// MaxFrontier of them.
+type FrontierPolicy int
+
+const (
+	// FrontierBlock holds the IndexWorker back until RequestWorkers make room.
+	FrontierBlock FrontierPolicy = iota
+	// FrontierDrop throws new links away and records them on the Website.
+	FrontierDrop
+)
+
+// Frontier holds links waiting to be crawled. The IndexWorker pushes newly found
+// links and RequestWorkers pop them, so implementations must be safe to use from
+// multiple goroutines.
+type Frontier interface {
+	Push(link url.URL)
+	Pop() (url.URL, bool)
+	Len() int
+}
+
+// PriorityFrontier is a Frontier that can also jump a link ahead of the rest,
+// like the next page of a paginated listing.
+type PriorityFrontier interface {
+	Frontier
+	PushFront(link url.URL)
+}
+
+// NewFrontier creates an empty frontier that hands out links in the order the
+// strategy calls for.
+func NewFrontier(strategy Strategy) Frontier {
+	if strategy == DepthFirst {
+		return &LinkStack{links: make([]url.URL, 0, RequestBufferSize)}
+	}
+	return &LinkQueue{links: make([]url.URL, RequestBufferSize)}
+}
+
+// LinkQueue is a first in, first out Frontier used for breadth-first crawls.
+// The links are kept in a ring buffer, so pushing at either end and popping
+// don't move the rest of them.
+type LinkQueue struct {
+	mu sync.Mutex
+	// links holds count links starting at head, wrapping around the end.
+	links []url.URL
+	head  int
+	count int
+}
+
+// grow makes room for one more link, keeping them in order. The caller must
+// hold the lock.
+func (q *LinkQueue) grow() {
+	if q.count < len(q.links) {
+		return
+	}
+	links := make([]url.URL, max(2*len(q.links), RequestBufferSize))
+	n := copy(links, q.links[q.head:])
+	copy(links[n:], q.links[:q.head])
+	q.links = links
+	q.head = 0
+}
+
+func (q *LinkQueue) Push(link url.URL) {
+	q.mu.Lock()
+	q.grow()
+	q.links[(q.head+q.count)%len(q.links)] = link
+	q.count += 1
+	q.mu.Unlock()
+}
+
+// PushFront puts a link at the head of the queue, to be crawled next.
+func (q *LinkQueue) PushFront(link url.URL) {
+	q.mu.Lock()
+	q.grow()
+	q.head = (q.head - 1 + len(q.links)) % len(q.links)
+	q.links[q.head] = link
+	q.count += 1
+	q.mu.Unlock()
+}
+
+func (q *LinkQueue) Pop() (link url.URL, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.count == 0 {
+		return link, false
+	}
+	link = q.links[q.head]
+	// Don't hold on to the popped link's strings.
+	q.links[q.head] = url.URL{}
+	q.head = (q.head + 1) % len(q.links)
+	q.count -= 1
+	return link, true
+}
+
+func (q *LinkQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+// LinkStack is a last in, first out Frontier used for depth-first crawls.
+type LinkStack struct {
+	mu    sync.Mutex
+	links []url.URL
+}
+
+func (s *LinkStack) Push(link url.URL) {
+	s.mu.Lock()
+	s.links = append(s.links, link)
+	s.mu.Unlock()
+}
+
+// PushFront is Push, since the top of the stack is crawled next anyway.
+func (s *LinkStack) PushFront(link url.URL) {
+	s.Push(link)
+}
+
+func (s *LinkStack) Pop() (link url.URL, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.links) == 0 {
+		return link, false
+	}
+	link = s.links[len(s.links)-1]
+	s.links = s.links[:len(s.links)-1]
+	return link, true
+}
+
+func (s *LinkStack) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.links)
+}
@@ -0,0 +1,143 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"crawler/testutil"
+)
+
+func TestFrontierBlock(t *testing.T) {
+	// The root links to more missing pages than the errors buffer holds, so
+	// while the IndexWorker waits for room the RequestWorkers fill it and
+	// can only carry on if someone reads their errors.
+	const n, missing, max = 50, 1000, 100
+	pages := testutil.DenseSite(n)
+	for i := 0; i < missing; i += 1 {
+		pages["/"] += fmt.Sprintf(`<a href="/missing/%d">Missing</a>`, i)
+	}
+	server := httptest.NewServer(testutil.FixtureHandler(pages))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.Policy = CompositePolicy{}
+	config.MaxFrontier = max
+	config.FrontierPolicy = FrontierBlock
+	config.Stats = &CrawlStats{}
+	// Each page is only indexed once the frontier is below the cap, so it
+	// can't hold more than the cap plus that page's links afterwards.
+	var mu sync.Mutex
+	var over []string
+	config.OnPage = func(_ context.Context, page Webpage) {
+		if queued := config.Stats.Queued.Load(); queued >= int64(max+len(page.Links)) {
+			mu.Lock()
+			over = append(over, fmt.Sprintf("%s: %d", page.URL.Path, queued))
+			mu.Unlock()
+		}
+	}
+
+	done := make(chan *Website)
+	go func() {
+		done <- CrawlerWithConfig(testutil.SeedURL(server), config)
+	}()
+	var site *Website
+	select {
+	case site = <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("crawl deadlocked with the frontier full")
+	}
+
+	if len(site.Pages) != n+1 {
+		t.Errorf("crawled %d pages, want %d", len(site.Pages), n+1)
+	}
+	if len(site.Errors) != missing {
+		t.Errorf("got %d errors, want %d", len(site.Errors), missing)
+	}
+	if len(over) > 0 {
+		t.Errorf("frontier went past the cap after indexing %v", over)
+	}
+}
+
+func TestFrontierMatrix(t *testing.T) {
+	// Every way of writing the same two pages has to dedup to one request
+	// each, whatever order the frontier hands links out in.
+	var log testutil.RequestLog
+	server := httptest.NewServer(log.Wrap(testutil.FixtureHandler(map[string]string{
+		"/": `<a href="/~user">1</a><a href="/%7Euser">2</a><a href="/%7euser">3</a>` +
+			`<a href="/a%2fb">4</a><a href="/a%2Fb">5</a>`,
+		"/~user": `<a href="/%7e%75ser">Me</a><a href="/a%2fb">Slash</a>`,
+		"/a/b":   `<a href="/%7Euser">User</a>`})))
+	defer server.Close()
+
+	for _, strategy := range []Strategy{BreadthFirst, DepthFirst} {
+		for _, policy := range []FrontierPolicy{FrontierBlock, FrontierDrop} {
+			log = testutil.RequestLog{}
+			config := DefaultConfig()
+			config.DebounceTimeout = 200 * time.Millisecond
+			config.Strategy = strategy
+			config.FrontierPolicy = policy
+			config.MaxFrontier = 10
+			site := CrawlerWithConfig(testutil.SeedURL(server), config)
+
+			name := fmt.Sprintf("strategy %d, frontier policy %d", strategy, policy)
+			if len(site.Pages) != 3 {
+				t.Errorf("%s: pages = %v, want 3", name, pageKeys(site))
+			}
+			if len(site.Dropped) > 0 {
+				t.Errorf("%s: dropped %v under the cap", name, site.Dropped)
+			}
+			requests := make(map[string]int)
+			for _, path := range log.Paths() {
+				requests[path] += 1
+			}
+			for _, path := range []string{"/", "/~user", "/a/b"} {
+				if requests[path] != 1 {
+					t.Errorf("%s: %s requested %d times, want once", name, path, requests[path])
+				}
+			}
+		}
+	}
+}
+
+func TestLinkQueue(t *testing.T) {
+	// Check the ring buffer against a plain slice, through enough pushes at
+	// both ends and pops to wrap around and grow it a few times.
+	link := func(i int) url.URL {
+		return url.URL{Path: fmt.Sprintf("/%d", i)}
+	}
+	var queue LinkQueue
+	var want []url.URL
+	for i := 0; i < 5000; i += 1 {
+		switch {
+		case i%7 == 0:
+			queue.PushFront(link(i))
+			want = append([]url.URL{link(i)}, want...)
+		case i%3 == 0 && len(want) > 0:
+			got, ok := queue.Pop()
+			if !ok || got != want[0] {
+				t.Fatalf("step %d: Pop = %s, %v, want %s", i, got.Path, ok, want[0].Path)
+			}
+			want = want[1:]
+		default:
+			queue.Push(link(i))
+			want = append(want, link(i))
+		}
+		if queue.Len() != len(want) {
+			t.Fatalf("step %d: Len = %d, want %d", i, queue.Len(), len(want))
+		}
+	}
+	for _, next := range want {
+		if got, ok := queue.Pop(); !ok || got != next {
+			t.Fatalf("Pop = %s, %v, want %s", got.Path, ok, next.Path)
+		}
+	}
+	if _, ok := queue.Pop(); ok {
+		t.Error("Pop on an empty queue succeeded")
+	}
+}
@@ -0,0 +1,31 @@
+package crawler
+
+import "log"
+
+// LogLevel controls how much the crawler logs. The zero value is LogInfo.
+type LogLevel int
+
+const (
+	// LogError logs only failed requests and other errors.
+	LogError LogLevel = iota - 1
+	// LogInfo adds warnings and a periodic progress summary.
+	LogInfo
+	// LogDebug adds a line for every page requested and indexed.
+	LogDebug
+)
+
+// ProgressInterval is how many indexed pages pass between progress summaries.
+const ProgressInterval = 100
+
+// Logf logs a message if the config's LogLevel is at least level. Messages go
+// to the config's Logger, or the standard logger if it is nil.
+func (c *CrawlerConfig) Logf(level LogLevel, format string, v ...any) {
+	if c.LogLevel < level {
+		return
+	}
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
@@ -0,0 +1,174 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"sync"
+)
+
+// pageJSON is how a Webpage is written out as JSON.
+type pageJSON struct {
+	URL             string            `json:"url"`
+	StatusCode      int               `json:"status"`
+	Title           string            `json:"title,omitempty"`
+	Lang            string            `json:"lang,omitempty"`
+	FetchMillis     int64             `json:"fetch_ms"`
+	Links           []string          `json:"links"`
+	Assets          []Asset           `json:"assets"`
+	ExternalLinks   []string          `json:"external_links,omitempty"`
+	EmbeddedLinks   []string          `json:"embedded_links,omitempty"`
+	StructuredData  []json.RawMessage `json:"structured_data,omitempty"`
+	ETag            string            `json:"etag,omitempty"`
+	LastModified    string            `json:"last_modified,omitempty"`
+	ContentHash     string            `json:"content_hash,omitempty"`
+	PaginationGroup string            `json:"pagination_group,omitempty"`
+	NextPage        string            `json:"next_page,omitempty"`
+	PrevPage        string            `json:"prev_page,omitempty"`
+	RedirectURL     string            `json:"redirect_url,omitempty"`
+	RefreshURL      string            `json:"refresh_url,omitempty"`
+	DiscoveredVia   string            `json:"discovered_via,omitempty"`
+	NoIndex         bool              `json:"noindex,omitempty"`
+	NoFollow        bool              `json:"nofollow,omitempty"`
+	AuthRequired    bool              `json:"auth_required,omitempty"`
+	MixedContent    []string          `json:"mixed_content,omitempty"`
+	Feeds           []Feed            `json:"feeds,omitempty"`
+}
+
+// MarshalJSON writes a page with its URLs as strings and its fetch duration in
+// milliseconds. The raw Body and Forms are left out.
+func (page Webpage) MarshalJSON() ([]byte, error) {
+	out := pageJSON{
+		URL:             page.URL.String(),
+		StatusCode:      page.StatusCode,
+		Title:           page.Title,
+		Lang:            page.Lang,
+		FetchMillis:     page.FetchDuration.Milliseconds(),
+		Links:           make([]string, 0, len(page.Links)),
+		Assets:          page.Assets,
+		StructuredData:  page.StructuredData,
+		ETag:            page.ETag,
+		LastModified:    page.LastModified,
+		ContentHash:     page.ContentHash,
+		MixedContent:    page.MixedContent,
+		Feeds:           page.Feeds,
+		NextPage:        page.NextPage.String(),
+		PrevPage:        page.PrevPage.String(),
+		RedirectURL:     page.RedirectURL.String(),
+		RefreshURL:      page.RefreshURL.String(),
+		PaginationGroup: page.PaginationGroup,
+		NoIndex:         page.NoIndex,
+		NoFollow:        page.NoFollow,
+		AuthRequired:    page.AuthRequired}
+	for _, link := range page.Links {
+		out.Links = append(out.Links, link.String())
+	}
+	for _, link := range page.ExternalLinks {
+		out.ExternalLinks = append(out.ExternalLinks, link.String())
+	}
+	for _, link := range page.EmbeddedLinks {
+		out.EmbeddedLinks = append(out.EmbeddedLinks, link.String())
+	}
+	if out.Assets == nil {
+		out.Assets = []Asset{}
+	}
+	if page.DiscoveredVia != 0 {
+		out.DiscoveredVia = page.DiscoveredVia.String()
+	}
+	return json.Marshal(out)
+}
+
+// CrawlNDJSON crawls a site using the DefaultConfig and writes each page to w as
+// a line of JSON the moment it's indexed, flushing w after every line if it can
+// be flushed, e.g. a *bufio.Writer or an http.ResponseWriter. Cancelling ctx or
+// failing to write stops the crawl early and returns the error. Pass a
+// RecordWriter to share w with other writers.
+func CrawlNDJSON(ctx context.Context, seed url.URL, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	out, ok := w.(*RecordWriter)
+	if !ok {
+		out = NewRecordWriter(w)
+	}
+	config := DefaultConfig()
+	config.OnPage = func(_ context.Context, page Webpage) {
+		if err := out.Encode(page); err != nil {
+			cancel()
+		}
+	}
+
+	site := crawl(ctx, []url.URL{seed}, config, nil, nil)
+	// Everything has been indexed by now, so this is the last write.
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if site.Err != nil {
+		return site.Err
+	}
+	return ctx.Err()
+}
+
+// RecordWriter lets several goroutines write records to one io.Writer, like
+// NDJSON pages and log lines, without them interleaving. Each Encode or Write is
+// written whole under a lock and then flushed. After the first failed write,
+// every call returns that error.
+type RecordWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewRecordWriter wraps w in a RecordWriter.
+func NewRecordWriter(w io.Writer) *RecordWriter {
+	return &RecordWriter{w: w}
+}
+
+// Encode writes v as a line of JSON.
+func (r *RecordWriter) Encode(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = r.Write(append(line, '\n'))
+	return err
+}
+
+// Write writes p as one record, so a log.Logger writing to a RecordWriter
+// never splits a line.
+func (r *RecordWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.w.Write(p)
+	if err == nil {
+		err = flush(r.w)
+	}
+	r.err = err
+	return n, err
+}
+
+// Flush flushes the underlying writer if it buffers its output, and reports the
+// first error from any write.
+func (r *RecordWriter) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = flush(r.w)
+	}
+	return r.err
+}
+
+// flush flushes w if it buffers its output.
+func flush(w io.Writer) error {
+	switch w := w.(type) {
+	case interface{ Flush() error }:
+		return w.Flush()
+	case interface{ Flush() }:
+		w.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package crawler
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// Option changes one setting of a crawl started with CrawlerWithOptions.
+type Option func(*crawlOptions)
+
+// crawlOptions is what the Options build up: the config, which starts from
+// DefaultConfig, and what isn't part of it.
+type crawlOptions struct {
+	config CrawlerConfig
+	ctx    context.Context
+	seeds  []url.URL
+}
+
+// CrawlerWithOptions crawls a site starting from seed, with DefaultConfig
+// changed by each option in turn, e.g.
+//
+//	site, err := CrawlerWithOptions(seed, WithUserAgent("docs-bot"), WithContext(ctx))
+//
+// Options that aren't covered by a WithXxx function can be set through
+// Configure. The seed is checked the same way as ParseSeed. The error is the
+// Website's Err, if the crawl was aborted, and the site holds whatever was
+// crawled either way.
+func CrawlerWithOptions(seed url.URL, opts ...Option) (*Website, error) {
+	link, err := ParseSeed(seed.String())
+	if err != nil {
+		return nil, err
+	}
+	options := crawlOptions{
+		config: DefaultConfig(),
+		ctx:    context.Background(),
+		seeds:  []url.URL{link}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	site := crawl(options.ctx, options.seeds, options.config, nil, nil)
+	return site, site.Err
+}
+
+// WithConfig replaces the whole config, for starting from a saved one instead
+// of DefaultConfig. Options after it change the passed config.
+func WithConfig(config CrawlerConfig) Option {
+	return func(o *crawlOptions) {
+		o.config = config
+	}
+}
+
+// Configure changes the config directly, for settings without a WithXxx.
+func Configure(change func(*CrawlerConfig)) Option {
+	return func(o *crawlOptions) {
+		change(&o.config)
+	}
+}
+
+// WithContext runs the crawl under ctx, see CrawlerWithContext.
+func WithContext(ctx context.Context) Option {
+	return func(o *crawlOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithSeeds adds entry points to the crawl besides the first seed, see
+// CrawlerSeeds.
+func WithSeeds(seeds ...url.URL) Option {
+	return func(o *crawlOptions) {
+		o.seeds = append(o.seeds, seeds...)
+	}
+}
+
+// WithUserAgent sets CrawlerConfig.UserAgent.
+func WithUserAgent(agent string) Option {
+	return func(o *crawlOptions) {
+		o.config.UserAgent = agent
+	}
+}
+
+// WithHeader adds one of CrawlerConfig.Headers.
+func WithHeader(key, value string) Option {
+	return func(o *crawlOptions) {
+		headers := make(map[string]string, len(o.config.Headers)+1)
+		for k, v := range o.config.Headers {
+			headers[k] = v
+		}
+		headers[key] = value
+		o.config.Headers = headers
+	}
+}
+
+// WithStrategy sets CrawlerConfig.Strategy.
+func WithStrategy(strategy Strategy) Option {
+	return func(o *crawlOptions) {
+		o.config.Strategy = strategy
+	}
+}
+
+// WithWorkers sets CrawlerConfig.Workers.
+func WithWorkers(workers int) Option {
+	return func(o *crawlOptions) {
+		o.config.Workers = workers
+	}
+}
+
+// WithMaxDepth sets CrawlerConfig.MaxDepth.
+func WithMaxDepth(depth int) Option {
+	return func(o *crawlOptions) {
+		o.config.MaxDepth = depth
+	}
+}
+
+// WithPolicy sets CrawlerConfig.Policy.
+func WithPolicy(policy Policy) Option {
+	return func(o *crawlOptions) {
+		o.config.Policy = policy
+	}
+}
+
+// WithScope sets CrawlerConfig.Scope.
+func WithScope(scope ScopePolicy) Option {
+	return func(o *crawlOptions) {
+		o.config.Scope = scope
+	}
+}
+
+// WithPathPrefixes sets CrawlerConfig.PathPrefixes.
+func WithPathPrefixes(prefixes ...string) Option {
+	return func(o *crawlOptions) {
+		o.config.PathPrefixes = prefixes
+	}
+}
+
+// WithResponseTimeout sets CrawlerConfig.ResponseTimeout.
+func WithResponseTimeout(timeout time.Duration) Option {
+	return func(o *crawlOptions) {
+		o.config.ResponseTimeout = timeout
+	}
+}
+
+// WithDebounce sets CrawlerConfig.DebounceTimeout.
+func WithDebounce(timeout time.Duration) Option {
+	return func(o *crawlOptions) {
+		o.config.DebounceTimeout = timeout
+	}
+}
+
+// WithMaxBytes sets CrawlerConfig.MaxBytes.
+func WithMaxBytes(max int64) Option {
+	return func(o *crawlOptions) {
+		o.config.MaxBytes = max
+	}
+}
+
+// WithVisitors sets CrawlerConfig.Visitors.
+func WithVisitors(visitors ...Visitor) Option {
+	return func(o *crawlOptions) {
+		o.config.Visitors = visitors
+	}
+}
+
+// WithOnPage sets CrawlerConfig.OnPage.
+func WithOnPage(onPage func(ctx context.Context, page Webpage)) Option {
+	return func(o *crawlOptions) {
+		o.config.OnPage = onPage
+	}
+}
+
+// WithStats sets CrawlerConfig.Stats.
+func WithStats(stats *CrawlStats) Option {
+	return func(o *crawlOptions) {
+		o.config.Stats = stats
+	}
+}
+
+// WithLogLevel sets CrawlerConfig.LogLevel.
+func WithLogLevel(level LogLevel) Option {
+	return func(o *crawlOptions) {
+		o.config.LogLevel = level
+	}
+}
@@ -0,0 +1,206 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"crawler/backfill"
+)
+
+// DefaultRateLimit is the minimum time between two requests to the same host
+// under DefaultPolicy.
+const DefaultRateLimit = 100 * time.Millisecond
+
+// ErrDisallowed is returned by a Policy that refuses to let a URL be fetched.
+var ErrDisallowed = errors.New("disallowed by policy")
+
+// Policy decides how polite to be to the sites we crawl. RequestWorker calls
+// Acquire before every fetch and release once the response has been read.
+// Acquire may block (rate limiting, per-host concurrency) and returns an error
+// if the URL should not be fetched at all.
+type Policy interface {
+	Acquire(ctx context.Context, link *url.URL) (release func(), err error)
+}
+
+// DefaultPolicy honors robots.txt and waits DefaultRateLimit between requests
+// to the same host.
+func DefaultPolicy() Policy {
+	return CompositePolicy{
+		NewRobotsPolicy(""),
+		NewRateLimitPolicy(DefaultRateLimit),
+	}
+}
+
+// ConfigurablePolicy is a Policy that makes requests of its own, like
+// RobotsPolicy. The crawl hands it its config before starting, so those
+// requests go through the same client, TLS settings, proxy and headers as the
+// rest.
+type ConfigurablePolicy interface {
+	Policy
+	Configure(config *CrawlerConfig)
+}
+
+// CompositePolicy acquires each of its policies in order. If one of them fails,
+// the ones already acquired are released.
+type CompositePolicy []Policy
+
+func (c CompositePolicy) Acquire(ctx context.Context, link *url.URL) (func(), error) {
+	releases := make([]func(), 0, len(c))
+	release := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+
+	for _, policy := range c {
+		r, err := policy.Acquire(ctx, link)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
+// Configure passes the config on to each of the policies that want it.
+func (c CompositePolicy) Configure(config *CrawlerConfig) {
+	for _, policy := range c {
+		if configurable, ok := policy.(ConfigurablePolicy); ok {
+			configurable.Configure(config)
+		}
+	}
+}
+
+// RateLimitPolicy spaces out requests to the same host by at least Delay.
+type RateLimitPolicy struct {
+	Delay time.Duration
+	mu    sync.Mutex
+	next  map[string]time.Time
+}
+
+// NewRateLimitPolicy creates a RateLimitPolicy with the given per-host delay.
+func NewRateLimitPolicy(delay time.Duration) *RateLimitPolicy {
+	return &RateLimitPolicy{Delay: delay, next: make(map[string]time.Time)}
+}
+
+func (p *RateLimitPolicy) Acquire(ctx context.Context, link *url.URL) (func(), error) {
+	// Reserve the next free slot for this host, then wait for it outside the lock.
+	p.mu.Lock()
+	now := time.Now()
+	slot := p.next[link.Host]
+	if slot.Before(now) {
+		slot = now
+	}
+	p.next[link.Host] = slot.Add(p.Delay)
+	p.mu.Unlock()
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return func() {}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// RobotsPolicy refuses URLs disallowed by their host's robots.txt. Each host's
+// robots.txt is fetched once and cached; hosts without one allow everything.
+// It's fetched with the crawl's client and headers, see ConfigurablePolicy.
+type RobotsPolicy struct {
+	Agent  string
+	Client *http.Client // nil uses the crawl's client, or http.DefaultClient outside a crawl
+	mu     sync.Mutex
+	rules  map[string]*robotsEntry
+	config *CrawlerConfig
+}
+
+// robotsEntry is one host's robots.txt, fetched by whichever worker asks for
+// the host first while the others wait on once.
+type robotsEntry struct {
+	once  sync.Once
+	rules *backfill.RobotsRules
+}
+
+// NewRobotsPolicy creates a RobotsPolicy that follows the rules for agent, or
+// the "*" rules if agent is not mentioned. An empty agent follows the rules for
+// the crawl's UserAgent.
+func NewRobotsPolicy(agent string) *RobotsPolicy {
+	return &RobotsPolicy{Agent: agent, rules: make(map[string]*robotsEntry)}
+}
+
+// Configure has robots.txt fetched the way the crawl fetches pages.
+func (p *RobotsPolicy) Configure(config *CrawlerConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = config
+}
+
+func (p *RobotsPolicy) Acquire(ctx context.Context, link *url.URL) (func(), error) {
+	// Only the lookup is under the lock, so a slow robots.txt holds up the
+	// workers waiting on that host and no others.
+	p.mu.Lock()
+	entry, ok := p.rules[link.Host]
+	if !ok {
+		entry = &robotsEntry{}
+		p.rules[link.Host] = entry
+	}
+	config := p.config
+	p.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.rules = p.fetch(ctx, link, config)
+	})
+	if !entry.rules.Allowed(link.RequestURI()) {
+		return nil, ErrDisallowed
+	}
+	return func() {}, nil
+}
+
+// fetch gets the rules for link's host, giving up after the config's
+// ResponseTimeout. Any failure allows everything.
+func (p *RobotsPolicy) fetch(ctx context.Context, link *url.URL, config *CrawlerConfig) *backfill.RobotsRules {
+	robots := url.URL{Scheme: link.Scheme, Host: link.Host, Path: "/robots.txt"}
+	if config == nil {
+		config = &CrawlerConfig{Client: http.DefaultClient}
+	}
+	timeout := config.ResponseTimeout
+	if timeout <= 0 {
+		timeout = ResponseTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := NewRequest(ctx, http.MethodGet, &robots, config)
+	if err != nil {
+		return &backfill.RobotsRules{}
+	}
+	client := p.Client
+	if client == nil {
+		client = config.HTTPClient()
+	}
+	if config.Stats != nil {
+		config.Stats.StartRequest()
+		defer config.Stats.FinishRequest()
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		config.Logf(LogError, "could not fetch %s: %s\n", robots.String(), err)
+		return &backfill.RobotsRules{}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return &backfill.RobotsRules{}
+	}
+	agent := p.Agent
+	if agent == "" {
+		agent = config.UserAgent
+	}
+	return backfill.ParseRobots(config.CountBytes(response.Body), agent)
+}
@@ -0,0 +1,71 @@
+package crawler
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"crawler/testutil"
+)
+
+func TestRobotsUserAgent(t *testing.T) {
+	var log testutil.RequestLog
+	server := httptest.NewServer(log.Wrap(testutil.FixtureHandler(map[string]string{
+		"/robots.txt": "User-agent: *\nDisallow: /private\n\nUser-agent: docs-bot\nDisallow: /public\n",
+		"/":           `<a href="/public">Public</a><a href="/private">Private</a>`,
+		"/public":     `<a href="/">Home</a>`,
+		"/private":    `<a href="/">Home</a>`})))
+	defer server.Close()
+
+	// DefaultPolicy has no agent of its own, so it should follow the group
+	// for the crawl's User-Agent rather than "*".
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.UserAgent = "docs-bot/1.0"
+	CrawlerWithConfig(testutil.SeedURL(server), config)
+
+	if log.Requested("/public") {
+		t.Errorf("requests = %v, want /public disallowed for docs-bot", log.Paths())
+	}
+	if !log.Requested("/private") {
+		t.Errorf("requests = %v, want /private allowed for docs-bot", log.Paths())
+	}
+}
+
+func TestRobotsTimeout(t *testing.T) {
+	// robots.txt never answers; the crawl should give up on it after the
+	// ResponseTimeout and go on as if there were none.
+	pages := testutil.FixtureHandler(map[string]string{
+		"/":      `<a href="/about">About</a>`,
+		"/about": `<a href="/">Home</a>`})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			<-r.Context().Done()
+			return
+		}
+		pages.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.ResponseTimeout = 300 * time.Millisecond
+	var logged bytes.Buffer
+	config.Logger = log.New(&logged, "", 0)
+	start := time.Now()
+	site := CrawlerWithConfig(testutil.SeedURL(server), config)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("crawl took %s, want robots.txt abandoned after %s", elapsed, config.ResponseTimeout)
+	}
+	if _, ok := site.Pages["/about"]; !ok {
+		t.Errorf("pages = %v, want /about crawled", pageKeys(site))
+	}
+	if !strings.Contains(logged.String(), "could not fetch "+server.URL+"/robots.txt") {
+		t.Errorf("log = %q, want the robots.txt failure on the config's Logger", logged.String())
+	}
+}
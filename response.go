@@ -0,0 +1,71 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Action is what a ResponsePolicy wants done with a response.
+type Action int
+
+const (
+	// Parse the page for links and assets and add it to the sitemap.
+	Parse Action = iota
+	// Skip the page. Error statuses are recorded in Website.Errors.
+	Skip
+	// Retry the request after a backoff, up to MaxRetries times.
+	Retry
+	// Stop the whole crawl, e.g. on a 401 that means the session expired.
+	Stop
+)
+
+// ResponsePolicy decides what to do with a page's response before its body is
+// read. ctx is the crawl's context.
+type ResponsePolicy func(ctx context.Context, response *http.Response) Action
+
+// DefaultRetryStatuses are the statuses worth retrying: rate limiting and
+// temporary gateway and server errors.
+var DefaultRetryStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout}
+
+// DefaultResponsePolicy parses anything below 400, retries the
+// DefaultRetryStatuses, and skips other error statuses.
+func DefaultResponsePolicy(ctx context.Context, response *http.Response) Action {
+	return RetryStatusPolicy(DefaultRetryStatuses)(ctx, response)
+}
+
+// RetryStatusPolicy is DefaultResponsePolicy retrying the given statuses.
+func RetryStatusPolicy(statuses []int) ResponsePolicy {
+	return func(ctx context.Context, response *http.Response) Action {
+		switch {
+		case slices.Contains(statuses, response.StatusCode):
+			return Retry
+		case response.StatusCode >= 400:
+			return Skip
+		}
+		return Parse
+	}
+}
+
+// StopError is returned by CrawlPage when the ResponsePolicy stopped the crawl.
+type StopError struct {
+	WebpageError
+}
+
+// Backoff waits before retry number n (counting from 0), doubling the wait each
+// time. Returns early with the context's error if it's cancelled.
+func Backoff(ctx context.Context, base time.Duration, n int) error {
+	timer := time.NewTimer(base << n)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
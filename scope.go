@@ -0,0 +1,95 @@
+package crawler
+
+import (
+	"net/url"
+
+	"crawler/backfill"
+)
+
+// ScopePolicy decides which links the crawl follows. The IndexWorker asks it
+// about every link it finds, after the link has been normalized. Links that
+// aren't in scope are never queued.
+type ScopePolicy interface {
+	InScope(link url.URL) bool
+}
+
+// ScopeFunc adapts an ordinary function to a ScopePolicy.
+type ScopeFunc func(link url.URL) bool
+
+func (f ScopeFunc) InScope(link url.URL) bool {
+	return f(link)
+}
+
+// DefaultScope is the scope of a crawl that doesn't set CrawlerConfig.Scope:
+// links with one of the AllowedSchemes, on the seed's host after HostRewrite or
+// a host AllowHost allows, under one of the PathPrefixes.
+func (state *CrawlerState) DefaultScope(domain url.URL) ScopePolicy {
+	return CompositeScope{
+		SchemeScope(state.Config.AllowedSchemes),
+		AnyScope{
+			SameHostScope{Domain: domain, HostRewrite: state.Config.HostRewrite},
+			ScopeFunc(func(link url.URL) bool { return state.AllowedHost(link.Host) })},
+		PathPrefixScope(state.Config.PathPrefixes)}
+}
+
+// CompositeScope keeps links that every one of its policies keeps.
+type CompositeScope []ScopePolicy
+
+func (c CompositeScope) InScope(link url.URL) bool {
+	for _, scope := range c {
+		if !scope.InScope(link) {
+			return false
+		}
+	}
+	return true
+}
+
+// AnyScope keeps links that at least one of its policies keeps.
+type AnyScope []ScopePolicy
+
+func (a AnyScope) InScope(link url.URL) bool {
+	for _, scope := range a {
+		if scope.InScope(link) {
+			return true
+		}
+	}
+	return false
+}
+
+// SameHostScope keeps links on Domain's host, after swapping hosts according to
+// HostRewrite.
+type SameHostScope struct {
+	Domain      url.URL
+	HostRewrite map[string]string
+}
+
+func (s SameHostScope) InScope(link url.URL) bool {
+	fetch := backfill.RewriteHost(&link, s.HostRewrite)
+	return backfill.SameHost(&fetch, &s.Domain)
+}
+
+// SameRegisteredDomainScope keeps links on Domain's registered domain, so
+// crawling www.example.com also crawls blog.example.com and example.com.
+type SameRegisteredDomainScope struct {
+	Domain url.URL
+}
+
+func (s SameRegisteredDomainScope) InScope(link url.URL) bool {
+	return backfill.SameRegisteredDomain(&link, &s.Domain)
+}
+
+// PathPrefixScope keeps links whose path starts with one of the prefixes, see
+// backfill.HasPathPrefix. An empty PathPrefixScope keeps everything.
+type PathPrefixScope []string
+
+func (p PathPrefixScope) InScope(link url.URL) bool {
+	return backfill.HasPathPrefix(&link, p)
+}
+
+// SchemeScope keeps links with one of the schemes, or one of
+// backfill.DefaultSchemes if it is nil.
+type SchemeScope []string
+
+func (s SchemeScope) InScope(link url.URL) bool {
+	return backfill.SchemeAllowed(&link, s)
+}
@@ -0,0 +1,120 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"crawler/testutil"
+)
+
+// scopeSite serves www.example.test, blog.example.test and other.test from one
+// server, for crawling with a PinnedDialer.
+func scopeSite() *httptest.Server {
+	hosts := map[string]http.Handler{
+		"www.example.test": testutil.FixtureHandler(map[string]string{
+			"/": `<a href="/docs/intro">Intro</a><a href="/about">About</a>` +
+				`<a href="http://blog.example.test/docs/post">Post</a>` +
+				`<a href="http://blog.example.test/news">News</a>` +
+				`<a href="http://other.test/docs/page">Other</a>`,
+			"/docs/intro": `<a href="/">Home</a>`,
+			"/about":      `<a href="/">Home</a>`}),
+		"blog.example.test": testutil.FixtureHandler(map[string]string{
+			"/":          `<a href="/news">News</a>`,
+			"/docs/post": `<a href="/news">News</a>`,
+			"/news":      `<a href="/docs/post">Post</a>`}),
+		"other.test": testutil.FixtureHandler(map[string]string{
+			"/":          `<a href="/docs/page">Page</a>`,
+			"/docs/page": `<a href="/">Home</a>`})}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts[r.Host].ServeHTTP(w, r)
+	}))
+}
+
+// scopeConfig crawls scopeSite with scope, recording every request.
+func scopeConfig(server *httptest.Server, scope ScopePolicy) (CrawlerConfig, *RequestRecorder) {
+	var recorder RequestRecorder
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.DialContext = testutil.PinnedDialer(server)
+	config.Recorder = &recorder
+	config.Scope = scope
+	return config, &recorder
+}
+
+// checkRequested checks which of the links the crawl fetched.
+func checkRequested(t *testing.T, recorder *RequestRecorder, want []string, not []string) {
+	t.Helper()
+	for _, link := range want {
+		if !recorder.Requested(link) {
+			t.Errorf("requested %v, want %s", recorder.URLs(), link)
+		}
+	}
+	for _, link := range not {
+		if recorder.Requested(link) {
+			t.Errorf("requested %v, want %s out of scope", recorder.URLs(), link)
+		}
+	}
+}
+
+func TestSameRegisteredDomainScope(t *testing.T) {
+	server := scopeSite()
+	defer server.Close()
+
+	seed := url.URL{Scheme: "http", Host: "www.example.test", Path: "/"}
+	config, recorder := scopeConfig(server, SameRegisteredDomainScope{Domain: seed})
+	site := CrawlerWithConfig(seed, config)
+
+	checkRequested(t, recorder, []string{
+		"http://www.example.test/docs/intro",
+		"http://www.example.test/about",
+		"http://blog.example.test/docs/post",
+		"http://blog.example.test/news"}, []string{
+		"http://other.test/docs/page"})
+	if _, ok := site.Pages["//blog.example.test/news"]; !ok {
+		t.Errorf("pages = %v, want the subdomain's pages keyed by host", pageKeys(site))
+	}
+}
+
+func TestPathPrefixScope(t *testing.T) {
+	server := scopeSite()
+	defer server.Close()
+
+	// The seed is outside the prefix, but seeds are always crawled.
+	seed := url.URL{Scheme: "http", Host: "www.example.test", Path: "/"}
+	config, recorder := scopeConfig(server, CompositeScope{
+		SameRegisteredDomainScope{Domain: seed},
+		PathPrefixScope{"/docs/"}})
+	CrawlerWithConfig(seed, config)
+
+	checkRequested(t, recorder, []string{
+		"http://www.example.test/",
+		"http://www.example.test/docs/intro",
+		"http://blog.example.test/docs/post"}, []string{
+		"http://www.example.test/about",
+		"http://blog.example.test/news",
+		"http://other.test/docs/page"})
+}
+
+func TestOutOfScopeSeeds(t *testing.T) {
+	server := scopeSite()
+	defer server.Close()
+
+	seeds := []url.URL{
+		{Scheme: "http", Host: "www.example.test", Path: "/about"},
+		{Scheme: "http", Host: "blog.example.test", Path: "/"},
+		{Scheme: "http", Host: "other.test", Path: "/"}}
+	config, recorder := scopeConfig(server, SameRegisteredDomainScope{Domain: seeds[0]})
+	config.SeedsOnly = true
+	site := CrawlerSeedsWithConfig(seeds, config)
+
+	checkRequested(t, recorder, []string{
+		"http://www.example.test/about",
+		"http://blog.example.test/"}, []string{
+		"http://other.test/"})
+	if len(site.Pages) != 2 {
+		t.Errorf("pages = %v, want the two in-scope seeds", pageKeys(site))
+	}
+}
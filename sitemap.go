@@ -0,0 +1,138 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"crawler/backfill"
+)
+
+// MaxSitemaps caps how many sitemap files a sitemap index may pull in.
+const MaxSitemaps = 1000
+
+// VerifySitemap checks that every URL declared in a sitemap is reachable. It
+// fetches each declared URL once, like a crawl that never follows links, so the
+// returned site has a page (with its status code) for every declared URL that
+// loaded and an error for every one that didn't, discovered via
+// DiscoveredSitemap. Sitemap indexes are followed. Declared URLs that aren't
+// verified are listed in the site's Skipped: those on other hosts than the
+// sitemap's, and those the crawl skipped, e.g. for not being HTML.
+func VerifySitemap(sitemap url.URL, config CrawlerConfig) *Website {
+	var seeds []url.URL
+	var errs []WebpageError
+	var skipped []SkipError
+	// The crawl shares these, so sitemap fetches are rate limited and counted
+	// with the rest.
+	if config.Policy == nil {
+		config.Policy = CompositePolicy{}
+	}
+	if config.Stats == nil {
+		config.Stats = &CrawlStats{}
+	}
+	queue := []url.URL{sitemap}
+	seen := map[string]bool{sitemap.String(): true}
+	for len(queue) > 0 {
+		link := queue[0]
+		queue = queue[1:]
+
+		found, err := FetchSitemap(context.Background(), &link, &config)
+		if err != nil {
+			errs = append(errs, err.(WebpageError))
+			continue
+		}
+		for _, loc := range found.URLs {
+			seed, err := url.Parse(loc)
+			if err != nil {
+				skipped = append(skipped, SkipError{loc, "bad URL: " + err.Error()})
+				continue
+			}
+			if !backfill.SameHost(seed, &sitemap) {
+				skipped = append(skipped, SkipError{loc, "not on " + sitemap.Host})
+				continue
+			}
+			seeds = append(seeds, *seed)
+		}
+		for _, loc := range found.Sitemaps {
+			child, err := url.Parse(loc)
+			if err != nil || seen[child.String()] || len(seen) >= MaxSitemaps {
+				continue
+			}
+			seen[child.String()] = true
+			queue = append(queue, *child)
+		}
+	}
+
+	config.SeedsOnly = true
+	config.SeedDiscovery = DiscoveredSitemap
+	site := crawl(context.Background(), seeds, config, nil, nil)
+	if len(seeds) == 0 {
+		site.Domain = sitemap
+	}
+	site.mu.Lock()
+	site.Errors = append(errs, site.Errors...)
+	site.Skipped = append(skipped, site.Skipped...)
+	site.mu.Unlock()
+	return site
+}
+
+// FetchSitemap requests and parses a single sitemap file, within the config's
+// ResponseTimeout. It waits on the config's Policy like a page would and is
+// counted in its Stats. A request the Policy refuses or that fails, an error
+// status, a file over MaxBodySize or malformed XML is returned as a
+// WebpageError.
+func FetchSitemap(ctx context.Context, link *url.URL, config *CrawlerConfig) (backfill.Sitemap, error) {
+	if policy, ok := config.Policy.(ConfigurablePolicy); ok {
+		policy.Configure(config)
+	}
+	if config.Policy != nil {
+		release, err := config.Policy.Acquire(ctx, link)
+		if err != nil {
+			return backfill.Sitemap{}, WebpageError{URL: *link, Err: err.Error()}
+		}
+		defer release()
+	}
+	if config.Stats != nil {
+		config.Stats.StartRequest()
+		defer config.Stats.FinishRequest()
+	}
+	if config.ResponseTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.ResponseTimeout)
+		defer cancel()
+	}
+	request, err := NewRequest(ctx, http.MethodGet, link, config)
+	if err != nil {
+		return backfill.Sitemap{}, WebpageError{URL: *link, Err: err.Error()}
+	}
+	response, err := config.HTTPClient().Do(request)
+	if err != nil {
+		return backfill.Sitemap{}, WebpageError{URL: *link, Err: err.Error()}
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return backfill.Sitemap{}, WebpageError{URL: *link, Err: response.Status, StatusCode: response.StatusCode}
+	}
+	// Read one byte past the cap, to tell a file that fits exactly from one
+	// that was cut off.
+	var body io.Reader = config.CountBytes(response.Body)
+	if config.MaxBodySize > 0 {
+		body = io.LimitReader(body, config.MaxBodySize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return backfill.Sitemap{}, WebpageError{URL: *link, Err: err.Error(), StatusCode: response.StatusCode}
+	}
+	if config.MaxBodySize > 0 && int64(len(data)) > config.MaxBodySize {
+		msg := fmt.Sprintf("sitemap larger than %d bytes", config.MaxBodySize)
+		return backfill.Sitemap{}, WebpageError{URL: *link, Err: msg, StatusCode: response.StatusCode}
+	}
+	sitemap, err := backfill.ParseSitemap(bytes.NewReader(data))
+	if err != nil {
+		return backfill.Sitemap{}, WebpageError{URL: *link, Err: fmt.Sprintf("bad sitemap: %s", err), StatusCode: response.StatusCode}
+	}
+	return sitemap, nil
+}
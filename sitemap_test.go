@@ -0,0 +1,169 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"crawler/testutil"
+)
+
+// sitemapSite serves pages like FixtureSite, plus a /sitemap.xml declaring
+// locs, with their host filled in, and JSON for any .json path.
+func sitemapSite(pages map[string]string, locs ...string) *httptest.Server {
+	handler := testutil.FixtureHandler(pages)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".json") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("{}"))
+			return
+		}
+		if r.URL.Path != "/sitemap.xml" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+			`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`))
+		for _, loc := range locs {
+			if loc[0] == '/' {
+				loc = server.URL + loc
+			}
+			w.Write([]byte("<url><loc>" + loc + "</loc></url>"))
+		}
+		w.Write([]byte("</urlset>"))
+	}))
+	return server
+}
+
+func TestVerifySitemapDiscovery(t *testing.T) {
+	server := sitemapSite(map[string]string{
+		"/":      `<a href="/about">About</a>`,
+		"/about": `<a href="/">Home</a>`}, "/", "/about")
+	defer server.Close()
+
+	sitemap, _ := url.Parse(server.URL + "/sitemap.xml")
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	site := VerifySitemap(*sitemap, config)
+
+	for _, key := range []string{"/", "/about"} {
+		if page, ok := site.Pages[key]; !ok {
+			t.Errorf("%s wasn't verified, pages = %v", key, pageKeys(site))
+		} else if page.DiscoveredVia != DiscoveredSitemap {
+			t.Errorf("%s discovered via %s, want sitemap", key, page.DiscoveredVia)
+		}
+	}
+}
+
+func TestVerifySitemapSkipped(t *testing.T) {
+	server := sitemapSite(map[string]string{
+		"/":      `<a href="/about">About</a>`,
+		"/about": `<a href="/">Home</a>`},
+		"/", "/feed.json", "/missing", "https://other.example/page")
+	defer server.Close()
+
+	sitemap, _ := url.Parse(server.URL + "/sitemap.xml")
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	site := VerifySitemap(*sitemap, config)
+
+	// Only / verifies; the rest each have to turn up somewhere in the report.
+	if len(site.Pages) != 1 {
+		t.Errorf("pages = %v, want just /", pageKeys(site))
+	}
+	if len(site.Errors) != 1 || site.Errors[0].StatusCode != http.StatusNotFound {
+		t.Errorf("errors = %v, want a 404 for /missing", site.Errors)
+	}
+	skipped := make(map[string]bool)
+	for _, skip := range site.Skipped {
+		skipped[skip.URL] = true
+	}
+	for _, link := range []string{server.URL + "/feed.json", "https://other.example/page"} {
+		if !skipped[link] {
+			t.Errorf("skipped = %v, want %s", site.Skipped, link)
+		}
+	}
+}
+
+func TestFetchSitemapMaxBodySize(t *testing.T) {
+	server := sitemapSite(nil, "/a", "/b", "/c")
+	defer server.Close()
+
+	sitemap, _ := url.Parse(server.URL + "/sitemap.xml")
+	config := DefaultConfig()
+	if _, err := FetchSitemap(context.Background(), sitemap, &config); err != nil {
+		t.Fatalf("FetchSitemap = %v, want it to fit in the default MaxBodySize", err)
+	}
+	config.MaxBodySize = 64
+	if _, err := FetchSitemap(context.Background(), sitemap, &config); err == nil {
+		t.Error("FetchSitemap read a sitemap over MaxBodySize")
+	}
+}
+
+func TestFetchSitemapTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	sitemap, _ := url.Parse(server.URL + "/sitemap.xml")
+	config := DefaultConfig()
+	config.ResponseTimeout = 100 * time.Millisecond
+	start := time.Now()
+	if _, err := FetchSitemap(context.Background(), sitemap, &config); err == nil {
+		t.Error("FetchSitemap succeeded against a server that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("FetchSitemap took %s, want it cut off after %s", elapsed, config.ResponseTimeout)
+	}
+}
+
+func TestVerifySitemapPolicy(t *testing.T) {
+	var log testutil.RequestLog
+	server := httptest.NewServer(log.Wrap(testutil.FixtureHandler(map[string]string{
+		"/robots.txt": "User-agent: *\nDisallow: /private\n",
+		"/":           `<a href="/about">About</a>`})))
+	defer server.Close()
+
+	// The sitemap is disallowed, so it must be refused like any page would.
+	sitemap, _ := url.Parse(server.URL + "/private/sitemap.xml")
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.Stats = &CrawlStats{}
+	site := VerifySitemap(*sitemap, config)
+
+	if log.Requested("/private/sitemap.xml") {
+		t.Errorf("requests = %v, want the disallowed sitemap skipped", log.Paths())
+	}
+	if len(site.Errors) != 1 {
+		t.Errorf("errors = %v, want the sitemap refused", site.Errors)
+	}
+	// Only robots.txt went out, and it was counted.
+	if requests := config.Stats.Requests.Load(); requests != int64(len(log.Paths())) {
+		t.Errorf("Stats.Requests = %d, want %d for %v", requests, len(log.Paths()), log.Paths())
+	}
+}
+
+func TestVerifySitemapStats(t *testing.T) {
+	server := sitemapSite(map[string]string{
+		"/":      `<a href="/about">About</a>`,
+		"/about": `<a href="/">Home</a>`}, "/", "/about")
+	defer server.Close()
+
+	sitemap, _ := url.Parse(server.URL + "/sitemap.xml")
+	config := DefaultConfig()
+	config.DebounceTimeout = 200 * time.Millisecond
+	config.Stats = &CrawlStats{}
+	VerifySitemap(*sitemap, config)
+
+	// robots.txt, the sitemap and both pages.
+	if requests := config.Stats.Requests.Load(); requests != 4 {
+		t.Errorf("Stats.Requests = %d, want 4", requests)
+	}
+}
@@ -0,0 +1,191 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// CrawlStats counts what a crawl has done so far. The workers update it
+// atomically, so it can be read while the crawl is still running.
+//
+// Every fetch (pages, HEAD probes, asset checks, and the robots.txt files a
+// RobotsPolicy reads while a RequestWorker waits on it) happens on one of the
+// Workers RequestWorkers, so PeakInFlight never exceeds Workers. VerifySitemap
+// fetches its sitemaps before the crawl starts, and counts them too.
+type CrawlStats struct {
+	Requests     atomic.Int64 // requests sent for pages, assets, robots.txt and sitemaps
+	Failures     atomic.Int64 // pages that failed to crawl
+	InFlight     atomic.Int64 // requests waiting on a response
+	PeakInFlight atomic.Int64 // most requests ever in flight at once
+	Workers      atomic.Int64 // RequestWorkers fetching in parallel
+	Queued       atomic.Int64 // links waiting in the frontier
+	Indexed      atomic.Int64 // pages added to the sitemap
+	BrokenLinks  atomic.Int64 // pages that returned a 4xx/5xx status
+	Bytes        atomic.Int64 // response body bytes downloaded
+}
+
+// StartRequest counts a request going out.
+func (s *CrawlStats) StartRequest() {
+	s.Requests.Add(1)
+	inFlight := s.InFlight.Add(1)
+	for {
+		peak := s.PeakInFlight.Load()
+		if inFlight <= peak || s.PeakInFlight.CompareAndSwap(peak, inFlight) {
+			return
+		}
+	}
+}
+
+// FinishRequest counts a request coming back.
+func (s *CrawlStats) FinishRequest() {
+	s.InFlight.Add(-1)
+}
+
+// RequestRecorder keeps the URL of every page and asset the RequestWorkers
+// fetch, in the order they were fetched, so tests can assert exactly what a
+// crawl requested and what robots.txt, the Scope and other filters held back.
+// The zero value is ready to use and it is safe for concurrent use.
+type RequestRecorder struct {
+	mu   sync.Mutex
+	urls []string
+}
+
+// Record adds a fetched URL.
+func (r *RequestRecorder) Record(link *url.URL) {
+	r.mu.Lock()
+	r.urls = append(r.urls, link.String())
+	r.mu.Unlock()
+}
+
+// URLs gets a copy of every URL recorded so far.
+func (r *RequestRecorder) URLs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.urls)
+}
+
+// Requested determines if a URL was fetched.
+func (r *RequestRecorder) Requested(link string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Contains(r.urls, link)
+}
+
+// ErrMaxBytes is returned by a ByteCounter once the crawl has downloaded its
+// MaxBytes.
+var ErrMaxBytes = errors.New("download limit reached")
+
+// ByteCounter counts the bytes read through it on Stats.Bytes. Once Stats.Bytes
+// reaches Max, if it is positive, reads fail with ErrMaxBytes and Limited is set.
+// A nil Stats counts nothing.
+type ByteCounter struct {
+	R       io.Reader
+	Stats   *CrawlStats
+	Max     int64
+	Limited bool
+}
+
+// CountBytes wraps a response body to count it against the config's Stats and
+// MaxBytes.
+func (c *CrawlerConfig) CountBytes(r io.Reader) *ByteCounter {
+	return &ByteCounter{R: r, Stats: c.Stats, Max: c.MaxBytes}
+}
+
+func (c *ByteCounter) Read(p []byte) (int, error) {
+	if c.Stats == nil {
+		return c.R.Read(p)
+	}
+	if c.Max > 0 && c.Stats.Bytes.Load() >= c.Max {
+		c.Limited = true
+		return 0, ErrMaxBytes
+	}
+	n, err := c.R.Read(p)
+	c.Stats.Bytes.Add(int64(n))
+	return n, err
+}
+
+// Metric is a point-in-time reading of one CrawlStats counter.
+type Metric struct {
+	Name  string
+	Help  string
+	Type  string // "counter" or "gauge"
+	Value int64
+}
+
+// Collectors reads every counter as a Metric named the way Prometheus expects.
+func (s *CrawlStats) Collectors() []Metric {
+	return []Metric{
+		{"crawler_requests_total", "Requests sent for pages, assets, robots.txt and sitemaps.", "counter", s.Requests.Load()},
+		{"crawler_failures_total", "Pages that failed to crawl.", "counter", s.Failures.Load()},
+		{"crawler_requests_in_flight", "Requests waiting on a response.", "gauge", s.InFlight.Load()},
+		{"crawler_requests_in_flight_peak", "Most requests ever in flight at once.", "gauge", s.PeakInFlight.Load()},
+		{"crawler_workers", "Request workers fetching in parallel.", "gauge", s.Workers.Load()},
+		{"crawler_queue_depth", "Links waiting to be crawled.", "gauge", s.Queued.Load()},
+		{"crawler_pages_indexed_total", "Pages added to the sitemap.", "counter", s.Indexed.Load()},
+		{"crawler_broken_links_total", "Pages that returned a 4xx/5xx status.", "counter", s.BrokenLinks.Load()},
+		{"crawler_downloaded_bytes_total", "Response body bytes downloaded.", "counter", s.Bytes.Load()},
+	}
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+func (s *CrawlStats) WritePrometheus(w io.Writer) error {
+	for _, m := range s.Collectors() {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.Name, m.Help, m.Name, m.Type, m.Name, m.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MetricsHandler serves the stats for a Prometheus server to scrape.
+func MetricsHandler(stats *CrawlStats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		stats.WritePrometheus(w)
+	})
+}
+
+// StatsReport is a point-in-time reading of every CrawlStats counter, for
+// encoding as JSON.
+type StatsReport struct {
+	Requests     int64 `json:"requests"`
+	Failures     int64 `json:"failures"`
+	InFlight     int64 `json:"in_flight"`
+	PeakInFlight int64 `json:"peak_in_flight"`
+	Workers      int64 `json:"workers"`
+	Queued       int64 `json:"queued"`
+	Indexed      int64 `json:"indexed"`
+	BrokenLinks  int64 `json:"broken_links"`
+	Bytes        int64 `json:"bytes"`
+}
+
+// Report reads every counter.
+func (s *CrawlStats) Report() StatsReport {
+	return StatsReport{
+		Requests:     s.Requests.Load(),
+		Failures:     s.Failures.Load(),
+		InFlight:     s.InFlight.Load(),
+		PeakInFlight: s.PeakInFlight.Load(),
+		Workers:      s.Workers.Load(),
+		Queued:       s.Queued.Load(),
+		Indexed:      s.Indexed.Load(),
+		BrokenLinks:  s.BrokenLinks.Load(),
+		Bytes:        s.Bytes.Load()}
+}
+
+// StatsHandler serves the stats as a JSON StatsReport, for checking on a crawl
+// with curl.
+func StatsHandler(stats *CrawlStats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats.Report())
+	})
+}
@@ -0,0 +1,78 @@
+package crawler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"crawler/backfill"
+)
+
+// Stylesheet is a same-host CSS file that was fetched and scanned with
+// ScanStylesheets set. Imports are the stylesheets it pulls in with @import,
+// which are scanned in turn, and Assets the fonts, images and other files it
+// loads with url().
+type Stylesheet struct {
+	URL     string
+	Imports []string
+	Assets  []Asset
+	Err     string
+}
+
+// IsStylesheet determines if an asset should be scanned as a stylesheet: it's a
+// .css file on the crawled host and the config asks for stylesheets to be
+// scanned.
+func IsStylesheet(link *url.URL, domain *url.URL, config *CrawlerConfig) bool {
+	fetch := backfill.RewriteHost(link, config.HostRewrite)
+	return config.ScanStylesheets && backfill.SameHost(&fetch, domain) &&
+		backfill.HasExtension(link, []string{"css"})
+}
+
+// ScanStylesheet fetches a stylesheet and lists what it references. The fetch
+// doubles as the asset's check.
+func ScanStylesheet(ctx context.Context, link *url.URL, domain *url.URL, config *CrawlerConfig) (Stylesheet, AssetCheck) {
+	sheet := Stylesheet{URL: link.String()}
+	check := AssetCheck{URL: link.String()}
+	fetch := backfill.RewriteHost(link, config.HostRewrite)
+	request, err := NewRequest(ctx, http.MethodGet, &fetch, config)
+	if err != nil {
+		sheet.Err, check.Err = err.Error(), err.Error()
+		return sheet, check
+	}
+	response, err := config.HTTPClient().Do(request)
+	if err != nil {
+		sheet.Err, check.Err = err.Error(), err.Error()
+		return sheet, check
+	}
+	defer response.Body.Close()
+	check.StatusCode = response.StatusCode
+	if response.StatusCode >= 400 {
+		sheet.Err = response.Status
+		return sheet, check
+	}
+
+	var body io.Reader = config.CountBytes(response.Body)
+	if config.MaxBodySize > 0 {
+		body = io.LimitReader(body, config.MaxBodySize)
+	}
+	imports, refs, err := backfill.ParseCSS(link, body)
+	if err != nil {
+		sheet.Err = err.Error()
+		return sheet, check
+	}
+	for _, imported := range imports {
+		sheet.Imports = append(sheet.Imports, imported.String())
+	}
+	for _, ref := range refs {
+		if !config.AssetExtensions.Allows(&ref) {
+			continue
+		}
+		rewritten := backfill.RewriteHost(&ref, config.HostRewrite)
+		external := !backfill.SameHost(&rewritten, domain)
+		if !external || config.IncludeExternalAssets {
+			sheet.Assets = append(sheet.Assets, Asset{URL: ref.String(), External: external})
+		}
+	}
+	return sheet, check
+}
@@ -0,0 +1,153 @@
+/*
+Package testutil has helpers for testing the crawler end to end against small
+sites served from memory.
+*/
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FixtureSite serves a set of HTML pages keyed by path, e.g.
+// {"/": `<a href="/about">About</a>`, "/about": "..."}. Paths that aren't in
+// the map get a 404. Close the server when the test is done.
+func FixtureSite(pages map[string]string) *httptest.Server {
+	return httptest.NewServer(FixtureHandler(pages))
+}
+
+// FixtureHandler is the handler behind FixtureSite, for tests that need to wrap
+// it or serve it some other way (e.g. over TLS).
+func FixtureHandler(pages map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(body))
+	})
+}
+
+// SeedURL gets the root URL of a test server to start crawling from.
+func SeedURL(server *httptest.Server) url.URL {
+	u, err := url.Parse(server.URL + "/")
+	if err != nil {
+		panic(err)
+	}
+	return *u
+}
+
+// MalformedPages is a fixture site whose pages have unclosed tags, stray end
+// tags and broken attributes. A browser still finds a link to every page, so a
+// crawl of it should find all four. The link inside the unterminated comment on
+// /three is not a real link.
+var MalformedPages = map[string]string{
+	"/": `<html><body><div><p>Unclosed paragraph
+		<a href="/one">One</a>
+		<table><tr><td><a href="/two">Two</a></div></span>
+		<img src="/logo.png"
+		<a href="/three">Three</a>`,
+	"/one":   `<ul><li><a href="/">Home<li><a href="/two">Two</a></ul></ul></body>`,
+	"/two":   `<a href="/three"><b><i>Three</a></b>`,
+	"/three": `<!-- unterminated comment <a href="/one">One</a>`,
+}
+
+// PinnedDialer dials the test server no matter which host is requested, for
+// crawling a fixture under a real hostname (CrawlerConfig.DialContext).
+func PinnedDialer(server *httptest.Server) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	addr := server.Listener.Addr().String()
+	return func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// DenseSite builds a fixture site of n pages at /0 through /n-1, plus the root,
+// where every page links to all n, for exercising the frontier under load.
+func DenseSite(n int) map[string]string {
+	var links strings.Builder
+	for i := 0; i < n; i += 1 {
+		fmt.Fprintf(&links, `<a href="/%d">%d</a>`, i, i)
+	}
+	pages := make(map[string]string, n+1)
+	pages["/"] = links.String()
+	for i := 0; i < n; i += 1 {
+		pages[fmt.Sprintf("/%d", i)] = links.String()
+	}
+	return pages
+}
+
+// SyntheticSite builds a fixture site of n pages at /0 through /n-1, plus a
+// root linking to /0, where page i links to the fanout pages after it (wrapping
+// around). Every page is reachable, so a crawl should index all n+1.
+func SyntheticSite(n, fanout int) map[string]string {
+	pages := make(map[string]string, n+1)
+	pages["/"] = `<a href="/0">0</a>`
+	for i := 0; i < n; i += 1 {
+		var links strings.Builder
+		for j := 1; j <= fanout; j += 1 {
+			next := (i + j) % n
+			fmt.Fprintf(&links, `<a href="/%d">%d</a>`, next, next)
+		}
+		pages[fmt.Sprintf("/%d", i)] = links.String()
+	}
+	return pages
+}
+
+// LatencySite serves a fixture site like FixtureSite but sleeps for latency
+// before answering each request, standing in for a slow origin when measuring
+// how worker count and buffer sizes affect a crawl.
+func LatencySite(pages map[string]string, latency time.Duration) *httptest.Server {
+	handler := FixtureHandler(pages)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// RequestLog records the paths a test server is asked for, robots.txt
+// included, for asserting what a crawl requested from the server's side.
+type RequestLog struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// Wrap logs every request before passing it to handler.
+func (l *RequestLog) Wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.mu.Lock()
+		l.paths = append(l.paths, r.URL.Path)
+		l.mu.Unlock()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Paths gets the requested paths in the order they arrived.
+func (l *RequestLog) Paths() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.paths...)
+}
+
+// Requested determines if path was asked for at least once.
+func (l *RequestLog) Requested(path string) bool {
+	for _, requested := range l.Paths() {
+		if requested == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,487 @@
+package crawler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+
+	"crawler/backfill"
+)
+
+// PageKey gets the key a page is stored under in Pages: its URLKey, with the host
+// in front for pages on other hosts, which are only crawled if the config's
+// AllowHost or Scope let them in.
+func (w *Website) PageKey(link *url.URL) string {
+	key := backfill.URLKey(link)
+	if fetch := backfill.RewriteHost(link, w.hostRewrite); !backfill.SameHost(&fetch, &w.Domain) {
+		return "//" + link.Host + key
+	}
+	return key
+}
+
+// AllPages iterates over the crawled pages sorted by their sitemap key. Use it
+// with range:
+//
+//	for path, page := range site.AllPages {
+//		...
+//	}
+//
+// The pages are copied out under the site's lock before iterating, so it is safe
+// to call while a crawl is still running and the loop body may call back into
+// the Website.
+func (w *Website) AllPages(yield func(string, Webpage) bool) {
+	w.mu.RLock()
+	keys := make([]string, 0, len(w.Pages))
+	pages := make(map[string]Webpage, len(w.Pages))
+	for key, page := range w.Pages {
+		keys = append(keys, key)
+		pages[key] = page
+	}
+	w.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !yield(key, pages[key]) {
+			return
+		}
+	}
+}
+
+// BrokenAssets lists the checked assets that returned a 4xx/5xx status or could
+// not be requested at all, sorted by URL. It is empty unless the site was
+// crawled with ValidateAssets.
+func (w *Website) BrokenAssets() []AssetCheck {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var broken []AssetCheck
+	for _, check := range w.AssetChecks {
+		if check.Broken() {
+			broken = append(broken, check)
+		}
+	}
+	sort.Slice(broken, func(i, j int) bool {
+		return broken[i].URL < broken[j].URL
+	})
+	return broken
+}
+
+// FormatURL formats a link for output: absolute, or relative to Domain if
+// RelativeURLs is set.
+func (w *Website) FormatURL(link *url.URL) string {
+	if w.RelativeURLs {
+		return backfill.RelativeURL(&w.Domain, link)
+	}
+	return link.String()
+}
+
+// WriteCSV writes one row per crawled page, sorted by URL, with the columns
+// url, status, links, assets and fetch_ms (fetch duration in milliseconds).
+func (w *Website) WriteCSV(out io.Writer) error {
+	var pages []Webpage
+	for _, page := range w.AllPages {
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URL.String() < pages[j].URL.String()
+	})
+
+	writer := csv.NewWriter(out)
+	writer.Write([]string{"url", "status", "links", "assets", "fetch_ms"})
+	for _, page := range pages {
+		writer.Write([]string{
+			w.FormatURL(&page.URL),
+			strconv.Itoa(page.StatusCode),
+			strconv.Itoa(len(page.Links)),
+			strconv.Itoa(len(page.Assets)),
+			strconv.FormatInt(page.FetchDuration.Milliseconds(), 10)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// AssetHosts counts the distinct assets served by each third-party host across
+// the whole site. It is empty unless the site was crawled with
+// IncludeExternalAssets.
+func (w *Website) AssetHosts() map[string]int {
+	seen := make(map[string]bool)
+	hosts := make(map[string]int)
+	for _, page := range w.AllPages {
+		for _, asset := range page.Assets {
+			if !asset.External || seen[asset.URL] {
+				continue
+			}
+			seen[asset.URL] = true
+			if link, err := url.Parse(asset.URL); err == nil {
+				hosts[link.Host] += 1
+			}
+		}
+	}
+	return hosts
+}
+
+// ExternalLinks maps each link to another host to the keys of the pages that
+// link to it, sorted. It is empty unless the site was crawled with
+// RecordExternalLinks.
+func (w *Website) ExternalLinks() map[string][]string {
+	links := make(map[string][]string)
+	for key, page := range w.AllPages {
+		seen := make(map[string]bool)
+		for _, link := range page.ExternalLinks {
+			target := link.String()
+			if !seen[target] {
+				seen[target] = true
+				links[target] = append(links[target], key)
+			}
+		}
+	}
+	return links
+}
+
+// WriteURLs writes the URL of every crawled page, one per line, sorted.
+func (w *Website) WriteURLs(out io.Writer) error {
+	var urls []string
+	for _, page := range w.AllPages {
+		urls = append(urls, w.FormatURL(&page.URL))
+	}
+	sort.Strings(urls)
+
+	for _, link := range urls {
+		if _, err := fmt.Fprintln(out, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SlowestPages gets up to n pages that took the longest to fetch, slowest first.
+func (w *Website) SlowestPages(n int) []Webpage {
+	var pages []Webpage
+	for _, page := range w.AllPages {
+		pages = append(pages, page)
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].FetchDuration > pages[j].FetchDuration
+	})
+	if len(pages) > n {
+		pages = pages[:n]
+	}
+	return pages
+}
+
+// PaginationGroups maps each pagination group to the keys of its pages, sorted.
+// Pages outside any group are left out. It is empty unless the site was crawled
+// with PaginationParams.
+func (w *Website) PaginationGroups() map[string][]string {
+	groups := make(map[string][]string)
+	for key, page := range w.AllPages {
+		if page.PaginationGroup != "" {
+			groups[page.PaginationGroup] = append(groups[page.PaginationGroup], key)
+		}
+	}
+	return groups
+}
+
+// DuplicateGroups lists the keys of pages that served byte-identical bodies, one
+// sorted group per distinct body, ordered by their first key. Pages with unique
+// content are left out. It is empty unless the site was crawled with
+// HashContent.
+func (w *Website) DuplicateGroups() [][]string {
+	byHash := make(map[string][]string)
+	for key, page := range w.AllPages {
+		if page.ContentHash != "" {
+			byHash[page.ContentHash] = append(byHash[page.ContentHash], key)
+		}
+	}
+	var groups [][]string
+	for _, keys := range byHash {
+		if len(keys) > 1 {
+			groups = append(groups, keys)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+	return groups
+}
+
+// HeavyPages gets the pages with more than maxLinks links or more than maxAssets
+// assets, sorted by key. A threshold of 0 or less is ignored.
+func (w *Website) HeavyPages(maxLinks, maxAssets int) []Webpage {
+	var heavy []Webpage
+	for _, page := range w.AllPages {
+		if IsHeavy(page, maxLinks, maxAssets) {
+			heavy = append(heavy, page)
+		}
+	}
+	return heavy
+}
+
+// IsHeavy determines if a page has more than maxLinks links or more than
+// maxAssets assets. A threshold of 0 or less is ignored.
+func IsHeavy(page Webpage, maxLinks, maxAssets int) bool {
+	return (maxLinks > 0 && len(page.Links) > maxLinks) ||
+		(maxAssets > 0 && len(page.Assets) > maxAssets)
+}
+
+// MixedContentIssue is an asset loaded over plain HTTP by an HTTPS page, which
+// browsers block or warn about.
+type MixedContentIssue struct {
+	Page  url.URL
+	Asset string
+}
+
+// FindMixedContent lists the http: assets on a page served over https. Assets
+// on other hosts are only seen if the crawl keeps them, see
+// CrawlerConfig.IncludeExternalAssets.
+func FindMixedContent(page Webpage) []MixedContentIssue {
+	if page.URL.Scheme != "https" {
+		return nil
+	}
+	var issues []MixedContentIssue
+	for _, asset := range page.Assets {
+		link, err := url.Parse(asset.URL)
+		if err == nil && link.Scheme == "http" {
+			issues = append(issues, MixedContentIssue{page.URL, asset.URL})
+		}
+	}
+	return issues
+}
+
+// UncrawledLinks lists the same-host links, sorted and without duplicates, that
+// don't lead to a crawled page: they failed, were redirected to a login page, or
+// were never fetched at all, e.g. because of PathPrefixes, robots.txt or a full
+// frontier. Pages crawled with StreamLinks don't keep their links, so their
+// links aren't checked.
+func (w *Website) UncrawledLinks() []url.URL {
+	w.mu.RLock()
+	crawled := make(map[string]bool, len(w.Pages))
+	for key, page := range w.Pages {
+		crawled[key] = !page.AuthRequired
+	}
+	normalize, rewrite := w.normalize, w.hostRewrite
+	w.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var links []url.URL
+	for _, page := range w.AllPages {
+		for _, link := range page.Links {
+			if fetch := backfill.RewriteHost(&link, rewrite); !backfill.SameHost(&fetch, &w.Domain) {
+				continue
+			}
+			backfill.NormalizeURL(&link, normalize)
+			key := backfill.URLKey(&link)
+			if !crawled[key] && !seen[key] {
+				seen[key] = true
+				links = append(links, link)
+			}
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].String() < links[j].String()
+	})
+	return links
+}
+
+// BrokenLink is a page that returned a 4xx/5xx status, with the keys of the
+// crawled pages that link to it.
+type BrokenLink struct {
+	URL        url.URL
+	StatusCode int
+	Referrers  []string
+}
+
+// BrokenLinks lists the pages that returned a 4xx/5xx status, sorted by URL,
+// along with every page that links to each of them. Pages crawled with
+// StreamLinks don't keep their links, so they aren't among the referrers.
+func (w *Website) BrokenLinks() []BrokenLink {
+	w.mu.RLock()
+	broken := make(map[string]*BrokenLink)
+	var keys []string
+	for _, pageErr := range w.Errors {
+		key := w.PageKey(&pageErr.URL)
+		if pageErr.StatusCode < 400 || broken[key] != nil {
+			continue
+		}
+		broken[key] = &BrokenLink{URL: pageErr.URL, StatusCode: pageErr.StatusCode}
+		keys = append(keys, key)
+	}
+	normalize, rewrite := w.normalize, w.hostRewrite
+	w.mu.RUnlock()
+
+	for referrer, page := range w.AllPages {
+		seen := make(map[string]bool)
+		for _, link := range page.Links {
+			if fetch := backfill.RewriteHost(&link, rewrite); !backfill.SameHost(&fetch, &w.Domain) {
+				continue
+			}
+			backfill.NormalizeURL(&link, normalize)
+			key := backfill.URLKey(&link)
+			if target := broken[key]; target != nil && !seen[key] {
+				seen[key] = true
+				target.Referrers = append(target.Referrers, referrer)
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return broken[keys[i]].URL.String() < broken[keys[j]].URL.String()
+	})
+	links := make([]BrokenLink, 0, len(keys))
+	for _, key := range keys {
+		links = append(links, *broken[key])
+	}
+	return links
+}
+
+// Snapshot copies the site under its lock, so a crawl still in progress can be
+// inspected without racing it. The pages themselves share their slices with the
+// original and Stats is the live counters, not a copy.
+func (w *Website) Snapshot() *Website {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return &Website{
+		Domain:       w.Domain,
+		Pages:        maps.Clone(w.Pages),
+		Errors:       slices.Clone(w.Errors),
+		Changed:      slices.Clone(w.Changed),
+		Dropped:      slices.Clone(w.Dropped),
+		Skipped:      slices.Clone(w.Skipped),
+		RelativeURLs: w.RelativeURLs,
+		AssetChecks:  maps.Clone(w.AssetChecks),
+		Stylesheets:  maps.Clone(w.Stylesheets),
+		MixedContent: slices.Clone(w.MixedContent),
+		Feeds:        slices.Clone(w.Feeds),
+		Truncated:    w.Truncated,
+		Stats:        w.Stats,
+		Err:          w.Err,
+		normalize:    w.normalize,
+		hostRewrite:  w.hostRewrite}
+}
+
+// MergeWebsites combines crawls of different sections of the same site, e.g. from
+// several machines each given their own seeds, into one Website. When more than
+// one crawl has a page, the earliest site passed wins. Errors, skipped and
+// dropped links for pages another crawl fetched fine are left out. Stats are
+// recomputed from the merged result, with request and byte counts summed. Links
+// are matched up using the first site's normalization and HostRewrite, so the
+// crawls should share a config. It is an error to merge crawls of different
+// hosts.
+func MergeWebsites(sites ...*Website) (*Website, error) {
+	if len(sites) == 0 {
+		return nil, errors.New("no sites to merge")
+	}
+	merged := &Website{
+		Domain:      sites[0].Domain,
+		Pages:       make(map[string]Webpage),
+		AssetChecks: make(map[string]AssetCheck),
+		Stylesheets: make(map[string]Stylesheet),
+		Stats:       &CrawlStats{},
+		normalize:   sites[0].normalize,
+		hostRewrite: sites[0].hostRewrite}
+	for _, site := range sites {
+		if !backfill.SameHost(&site.Domain, &merged.Domain) {
+			return nil, fmt.Errorf("can't merge crawls of %s and %s", merged.Domain.Host, site.Domain.Host)
+		}
+	}
+
+	var errs []error
+	changed := make(map[string]bool)
+	mixed := make(map[string]bool)
+	feeds := make(map[string]bool)
+	for _, site := range sites {
+		site.mu.RLock()
+		for key, page := range site.Pages {
+			if _, ok := merged.Pages[key]; !ok {
+				merged.Pages[key] = page
+			}
+		}
+		for link, check := range site.AssetChecks {
+			if _, ok := merged.AssetChecks[link]; !ok {
+				merged.AssetChecks[link] = check
+			}
+		}
+		for link, sheet := range site.Stylesheets {
+			if _, ok := merged.Stylesheets[link]; !ok {
+				merged.Stylesheets[link] = sheet
+			}
+		}
+		merged.Errors = append(merged.Errors, site.Errors...)
+		merged.Skipped = append(merged.Skipped, site.Skipped...)
+		merged.Dropped = append(merged.Dropped, site.Dropped...)
+		for _, issue := range site.MixedContent {
+			key := issue.Page.String() + " " + issue.Asset
+			if !mixed[key] {
+				mixed[key] = true
+				merged.MixedContent = append(merged.MixedContent, issue)
+			}
+		}
+		for _, feed := range site.Feeds {
+			if !feeds[feed.URL] {
+				feeds[feed.URL] = true
+				merged.Feeds = append(merged.Feeds, feed)
+			}
+		}
+		for _, key := range site.Changed {
+			if !changed[key] {
+				changed[key] = true
+				merged.Changed = append(merged.Changed, key)
+			}
+		}
+		if site.Err != nil {
+			errs = append(errs, site.Err)
+		}
+		merged.Truncated = merged.Truncated || site.Truncated
+		if site.Stats != nil {
+			merged.Stats.Requests.Add(site.Stats.Requests.Load())
+			merged.Stats.Bytes.Add(site.Stats.Bytes.Load())
+		}
+		site.mu.RUnlock()
+	}
+	merged.Err = errors.Join(errs...)
+
+	// A page one crawl failed on may have been fetched by another.
+	kept := merged.Errors[:0]
+	for _, pageErr := range merged.Errors {
+		if _, ok := merged.Pages[merged.PageKey(&pageErr.URL)]; ok {
+			continue
+		}
+		kept = append(kept, pageErr)
+	}
+	merged.Errors = kept
+	skips := make(map[string]bool)
+	skipped := merged.Skipped[:0]
+	for _, skip := range merged.Skipped {
+		if link, err := url.Parse(skip.URL); err == nil {
+			if _, ok := merged.Pages[merged.PageKey(link)]; ok {
+				continue
+			}
+		}
+		if !skips[skip.URL] {
+			skips[skip.URL] = true
+			skipped = append(skipped, skip)
+		}
+	}
+	merged.Skipped = skipped
+	drops := make(map[string]bool)
+	dropped := merged.Dropped[:0]
+	for _, link := range merged.Dropped {
+		key := merged.PageKey(&link)
+		if _, ok := merged.Pages[key]; ok || drops[key] {
+			continue
+		}
+		drops[key] = true
+		dropped = append(dropped, link)
+	}
+	merged.Dropped = dropped
+
+	merged.Stats.Indexed.Store(int64(len(merged.Pages)))
+	merged.Stats.Failures.Store(int64(len(merged.Errors)))
+	return merged, nil
+}